@@ -1,10 +1,14 @@
 package controllers
 
-import "github.com/revel/revel"
+import (
+	"github.com/revel/modules/jobs/app/jobs"
+	"github.com/revel/revel"
+)
 
 func init() {
 	revel.OnAppStart(func() {
 		BMInstance().BuildMaintenance()
+		jobs.Schedule(revel.Config.StringDefault("docker.healthcheck", "@every 30s"), jobs.Func(HPInstance().CheckHealth))
 	})
 }
 
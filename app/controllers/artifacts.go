@@ -0,0 +1,137 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//artifactManifest is written in the output directory of a successful build
+const artifactManifest = "manifest.json"
+
+//ArtifactPattern is a glob pattern (see filepath.Match) of files expected in /output
+//The build fails if a Required pattern match nothing
+type ArtifactPattern struct {
+	Pattern  string
+	Required bool
+}
+
+//Artifact is a file produced by a build, Name is relative to the output directory
+type Artifact struct {
+	Name   string
+	Size   int64
+	SHA256 string
+}
+
+//Manifest is the content of manifest.json
+type Manifest struct {
+	Project   string
+	TargetSys string
+	Ref       string
+	Release   string
+	BuildID   string
+	Artifacts []Artifact
+}
+
+//defaultArtifacts keep every file of /output when no Artifacts are declared for a sys
+var defaultArtifacts = []ArtifactPattern{{Pattern: "*"}}
+
+//collectArtifacts match the declared patterns in outputDir, checksum the files
+//and write the manifest
+func (b *Build) collectArtifacts(outputDir string) error {
+	patterns, ok := b.ProjectToBuild.Configuration.Artifacts[b.TargetSys]
+	if !ok {
+		patterns = defaultArtifacts
+	}
+
+	var missing []string
+	found := make(map[string]bool)
+	b.Artifacts = []Artifact{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(outputDir, pattern.Pattern))
+		if err != nil {
+			return fmt.Errorf("Invalid artifact pattern %s: %s", pattern.Pattern, err)
+		}
+		count := 0
+		for _, match := range matches {
+			name, err := filepath.Rel(outputDir, match)
+			if err != nil || b.isReservedOutput(name) {
+				continue
+			}
+			if stat, err := os.Stat(match); err != nil || stat.IsDir() {
+				continue
+			}
+			count++
+			if found[name] {
+				continue
+			}
+			artifact, err := newArtifact(outputDir, name)
+			if err != nil {
+				return err
+			}
+			found[name] = true
+			b.Artifacts = append(b.Artifacts, artifact)
+		}
+		if count == 0 && pattern.Required {
+			missing = append(missing, pattern.Pattern)
+		}
+	}
+	sort.Slice(b.Artifacts, func(i, j int) bool { return b.Artifacts[i].Name < b.Artifacts[j].Name })
+
+	manifest, err := json.MarshalIndent(Manifest{
+		Project:   b.ProjectToBuild.Name,
+		TargetSys: b.TargetSys,
+		Ref:       b.Commit,
+		Release:   b.ReleaseString(),
+		BuildID:   b.ID.Hex(),
+		Artifacts: b.Artifacts,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(outputDir, artifactManifest), manifest, 0644); err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Missing required artifacts %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+//newArtifact compute the size and SHA-256 of an output file
+func newArtifact(outputDir string, name string) (Artifact, error) {
+	artifact := Artifact{Name: filepath.ToSlash(name)}
+	file, err := os.Open(filepath.Join(outputDir, name))
+	if err != nil {
+		return artifact, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if artifact.Size, err = io.Copy(hash, file); err != nil {
+		return artifact, err
+	}
+	artifact.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return artifact, nil
+}
+
+//isReservedOutput return true for the files gogobuild writes itself in the output directory
+func (b *Build) isReservedOutput(name string) bool {
+	return name == "logs.txt" || name == deployLog || name == artifactManifest || name == b.outputTarName()
+}
+
+//hasArtifact return true if name is one of the build artifacts
+func (b *Build) hasArtifact(name string) bool {
+	for _, artifact := range b.Artifacts {
+		if artifact.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestCollectArtifacts(t *testing.T) {
+	files := map[string]string{
+		"ring_amd64.exe":           "binary",
+		"ring_i386.exe":            "other binary",
+		"doc/readme.txt":           "hello world",
+		"logs.txt":                 "build log",
+		deployLog:                  "deploy log",
+		"ring-win32~gitmaster.tar": "output archive",
+	}
+	tests := []struct {
+		name string
+		//Artifacts of win32, nil when the sys has none
+		patterns  []ArtifactPattern
+		artifacts []string
+		//Part of the error, empty when the build succeed
+		err string
+	}{
+		{
+			name:      "every file by default",
+			artifacts: []string{"ring_amd64.exe", "ring_i386.exe"},
+		},
+		{
+			name:      "patterns",
+			patterns:  []ArtifactPattern{{Pattern: "*_amd64.exe", Required: true}, {Pattern: "doc/*.txt"}},
+			artifacts: []string{"doc/readme.txt", "ring_amd64.exe"},
+		},
+		{
+			name:      "file matched twice",
+			patterns:  []ArtifactPattern{{Pattern: "*.exe"}, {Pattern: "ring_amd64.*", Required: true}},
+			artifacts: []string{"ring_amd64.exe", "ring_i386.exe"},
+		},
+		{
+			name:      "optional pattern missing",
+			patterns:  []ArtifactPattern{{Pattern: "*.exe", Required: true}, {Pattern: "*.msi"}},
+			artifacts: []string{"ring_amd64.exe", "ring_i386.exe"},
+		},
+		{
+			name:      "required pattern missing",
+			patterns:  []ArtifactPattern{{Pattern: "*.exe", Required: true}, {Pattern: "*.msi", Required: true}, {Pattern: "*.deb", Required: true}},
+			artifacts: []string{"ring_amd64.exe", "ring_i386.exe"},
+			err:       "*.msi, *.deb",
+		},
+		{
+			name:      "reserved files are not artifacts",
+			patterns:  []ArtifactPattern{{Pattern: "*.txt", Required: true}},
+			artifacts: []string{},
+			err:       "*.txt",
+		},
+		{
+			name:     "invalid pattern",
+			patterns: []ArtifactPattern{{Pattern: "[", Required: true}},
+			err:      "Invalid artifact pattern",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outputDir, err := ioutil.TempDir("", "gogobuild-artifacts")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(outputDir)
+			for name, content := range files {
+				os.MkdirAll(filepath.Dir(filepath.Join(outputDir, name)), 0777)
+				if err := ioutil.WriteFile(filepath.Join(outputDir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			build := &Build{ID: bson.NewObjectId(), TargetSys: "win32", Commit: "master", GitCommitID: "abc123"}
+			build.ProjectToBuild.Name = "ring"
+			if test.patterns != nil {
+				build.ProjectToBuild.Configuration.Artifacts = map[string][]ArtifactPattern{"win32": test.patterns}
+			}
+			err = build.collectArtifacts(outputDir)
+			if len(test.err) == 0 && err != nil {
+				t.Fatal(err)
+			}
+			if len(test.err) > 0 && (err == nil || !strings.Contains(err.Error(), test.err)) {
+				t.Fatalf("got error %v, want %q", err, test.err)
+			}
+			if test.artifacts == nil {
+				return
+			}
+
+			//The manifest is written even when a required artifact is missing
+			content, err := ioutil.ReadFile(filepath.Join(outputDir, artifactManifest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var manifest Manifest
+			if err := json.Unmarshal(content, &manifest); err != nil {
+				t.Fatal(err)
+			}
+			if manifest.Project != "ring" || manifest.TargetSys != "win32" || manifest.Ref != "master" ||
+				manifest.BuildID != build.ID.Hex() || manifest.Release != build.ReleaseString() {
+				t.Errorf("got manifest %+v", manifest)
+			}
+			var names []string
+			for i, artifact := range manifest.Artifacts {
+				names = append(names, artifact.Name)
+				sum := sha256.Sum256([]byte(files[artifact.Name]))
+				if artifact.Size != int64(len(files[artifact.Name])) || artifact.SHA256 != hex.EncodeToString(sum[:]) {
+					t.Errorf("%s: got size %d and SHA-256 %s", artifact.Name, artifact.Size, artifact.SHA256)
+				}
+				if i >= len(build.Artifacts) || build.Artifacts[i] != artifact {
+					t.Errorf("%s: not in the build artifacts %v", artifact.Name, build.Artifacts)
+				}
+			}
+			if strings.Join(names, ",") != strings.Join(test.artifacts, ",") || len(build.Artifacts) != len(names) {
+				t.Errorf("got artifacts %v, want %v", names, test.artifacts)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//BoltStore is the embedded BuildStore, everything is kept in a single file
+//Builds are bson encoded and keyed by their ObjectId
+type BoltStore struct {
+	db *bolt.DB
+}
+
+var buildsBucket = []byte("builds")
+var deploymentsBucket = []byte("deployments")
+
+//Init open (or create) the bolt database file
+func (s *BoltStore) Init(url string) error {
+	var err error
+	s.db, err = bolt.Open(url, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(buildsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(deploymentsBucket)
+		return err
+	})
+}
+
+func (s *BoltStore) put(tx *bolt.Tx, build *Build) error {
+	data, err := bson.Marshal(build)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(buildsBucket).Put([]byte(build.ID), data)
+}
+
+func (s *BoltStore) get(tx *bolt.Tx, id bson.ObjectId) (*Build, error) {
+	var build = new(Build)
+	data := tx.Bucket(buildsBucket).Get([]byte(id))
+	if data == nil {
+		return build, ErrBuildNotFound
+	}
+	return build, bson.Unmarshal(data, build)
+}
+
+//SaveBuild insert or replace a build
+func (s *BoltStore) SaveBuild(build *Build) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return s.put(tx, build)
+	})
+}
+
+//UpdateBuild update the state, timings, steps, artifacts, queue order and flags of a build
+func (s *BoltStore) UpdateBuild(build *Build) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		stored, err := s.get(tx, build.ID)
+		if err != nil {
+			return err
+		}
+		stored.State = build.State
+		stored.LastUpdated = time.Now()
+		stored.UpdateWorkerDuration = build.UpdateWorkerDuration
+		stored.StartDate = build.StartDate
+		stored.Priority = build.Priority
+		stored.QueuePosition = build.QueuePosition
+		stored.Steps = build.Steps
+		stored.Artifacts = build.Artifacts
+		stored.Deployed = build.Deployed
+		stored.Pruned = build.Pruned
+		return s.put(tx, stored)
+	})
+}
+
+//GetBuildByID return a build by it's id
+func (s *BoltStore) GetBuildByID(id string) (*Build, error) {
+	if !bson.IsObjectIdHex(id) {
+		return new(Build), ErrBuildNotFound
+	}
+	var build *Build
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		build, err = s.get(tx, bson.ObjectIdHex(id))
+		return err
+	})
+	return build, err
+}
+
+//GetBuildsByProject return the builds of a project, most recent first
+func (s *BoltStore) GetBuildsByProject(projectName string) ([]Build, error) {
+	var buildList []Build
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(buildsBucket).ForEach(func(k, v []byte) error {
+			var build Build
+			if err := bson.Unmarshal(v, &build); err != nil {
+				return err
+			}
+			if build.ProjectToBuild.Name == projectName {
+				buildList = append(buildList, build)
+			}
+			return nil
+		})
+	})
+	sort.Sort(buildsByDate(buildList))
+	return buildList, err
+}
+
+//GetUnfinishedBuilds return the builds not in a final state
+func (s *BoltStore) GetUnfinishedBuilds() ([]Build, error) {
+	var buildList []Build
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(buildsBucket).ForEach(func(k, v []byte) error {
+			var build Build
+			if err := bson.Unmarshal(v, &build); err != nil {
+				return err
+			}
+			if build.State.IsRunning() {
+				buildList = append(buildList, build)
+			}
+			return nil
+		})
+	})
+	return buildList, err
+}
+
+//SaveDeployment insert or replace a deployment
+func (s *BoltStore) SaveDeployment(deployment *Deployment) error {
+	data, err := bson.Marshal(deployment)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(deploymentsBucket).Put([]byte(deployment.ID), data)
+	})
+}
+
+//GetDeploymentsByProject return the deployments of a project, most recent first
+func (s *BoltStore) GetDeploymentsByProject(projectName string) ([]Deployment, error) {
+	deployments, err := s.findDeployments(func(d *Deployment) bool { return d.Project == projectName })
+	sort.Sort(deploymentsByDate(deployments))
+	return deployments, err
+}
+
+//GetUnfinishedDeployments return the deployments still running
+func (s *BoltStore) GetUnfinishedDeployments() ([]Deployment, error) {
+	return s.findDeployments(func(d *Deployment) bool { return d.State.IsRunning() })
+}
+
+func (s *BoltStore) findDeployments(match func(d *Deployment) bool) ([]Deployment, error) {
+	var deployments []Deployment
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deploymentsBucket).ForEach(func(k, v []byte) error {
+			var deployment Deployment
+			if err := bson.Unmarshal(v, &deployment); err != nil {
+				return err
+			}
+			if match(&deployment) {
+				deployments = append(deployments, deployment)
+			}
+			return nil
+		})
+	})
+	return deployments, err
+}
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestBoltStoreDeployments(t *testing.T) {
+	store, cleanup := newTestBoltStore(t)
+	defer cleanup()
+
+	now := time.Now()
+	build := bson.NewObjectId()
+	deployments := []*Deployment{
+		{ID: bson.NewObjectId(), BuildID: build, Project: "ring", Release: "2", State: Success, StartDate: now.Add(-time.Hour)},
+		{ID: bson.NewObjectId(), BuildID: bson.NewObjectId(), Project: "ring", Release: "3", State: Building, StartDate: now},
+		{ID: bson.NewObjectId(), BuildID: build, Project: "ring", Release: "1", State: Fail, StartDate: now.Add(-2 * time.Hour)},
+		{ID: bson.NewObjectId(), BuildID: bson.NewObjectId(), Project: "other", Release: "1", State: Success, StartDate: now},
+	}
+	for _, deployment := range deployments {
+		if err := store.SaveDeployment(deployment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	releases := func() string {
+		list, err := store.GetDeploymentsByProject("ring")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var releases string
+		for _, deployment := range list {
+			releases += deployment.Release
+		}
+		return releases
+	}
+	if got := releases(); got != "321" {
+		t.Errorf("got releases %s, expected the most recent first", got)
+	}
+
+	//A restart fail the deployments left running
+	if err := failUnfinishedDeployments(store); err != nil {
+		t.Fatal(err)
+	}
+	if unfinished, err := store.GetUnfinishedDeployments(); err != nil || len(unfinished) != 0 {
+		t.Errorf("got %v, %v, expected no unfinished deployment", unfinished, err)
+	}
+	list, _ := store.GetDeploymentsByProject("ring")
+	if list[0].State != Fail || list[0].ExitCode != -1 || list[0].EndDate.IsZero() || len(list[0].Error) == 0 {
+		t.Errorf("got %+v, expected an interrupted deployment", list[0])
+	}
+
+}
@@ -1,9 +1,9 @@
 package controllers
 
 import (
-	"fmt"
-	"io/ioutil"
+	"net/http"
 	"os"
+	"strconv"
 
 	"github.com/revel/revel"
 )
@@ -38,14 +38,36 @@ func (c BuildController) Detail() revel.Result {
 		return c.RenderJson(build)
 	}
 
-	logsPath := fmt.Sprintf("%s/public/output/%s/%d/%s/logs.txt", revel.BasePath, build.ProjectToBuild.Name, build.Date.Unix(), build.TargetSys)
-	logFile, err := ioutil.ReadFile(logsPath)
+	//Logs are streamed by the Logs action, the step logs are loaded when opened
+	_, err = os.Stat(revel.BasePath + build.DeployLogPath())
+	deployed := err == nil
+	return c.Render(build, deployed)
+}
+
+//Logs stream the build log as Server-Sent Events
+//Start at the offset param or at the Last-Event-ID of a reconnecting client
+//With an end param the offset to end bytes are sent as plain text, e.g the log of a step
+func (c BuildController) Logs() revel.Result {
+	build, err := BMInstance().GetBuildByID(c.Params.Get("id"))
 	if err != nil {
-		c.Flash.Error(err.Error())
-		revel.WARN.Println(err)
+		return c.NotFound(err.Error())
+	}
+	offset, _ := strconv.ParseInt(c.Params.Get("offset"), 10, 64)
+	if end, err := strconv.ParseInt(c.Params.Get("end"), 10, 64); err == nil {
+		return LogRange{
+			Path:   revel.BasePath + build.LogsPath(),
+			Offset: offset,
+			End:    end,
+		}
+	}
+	if lastID := c.Request.Header.Get("Last-Event-ID"); len(lastID) > 0 {
+		offset, _ = strconv.ParseInt(lastID, 10, 64)
+	}
+	return LogStream{
+		BuildID: build.ID.Hex(),
+		Path:    revel.BasePath + build.LogsPath(),
+		Offset:  offset,
 	}
-	logContent := string(logFile)
-	return c.Render(build, logContent)
 }
 
 //Retry a failed build
@@ -58,6 +80,24 @@ func (c BuildController) Retry() revel.Result {
 	return c.Redirect("/projects/%s/builds", build.ProjectToBuild.Name)
 }
 
+//Cancel a running build
+func (c BuildController) Cancel() revel.Result {
+	build, err := BMInstance().GetBuildByID(c.Params.Get("id"))
+	if err != nil {
+		c.Flash.Error(err.Error())
+		return c.Redirect("/projects/%s/builds", c.Params.Get("project"))
+	}
+	if err := BMInstance().CancelBuild(build); err != nil {
+		c.Flash.Error(err.Error())
+	} else {
+		c.Flash.Success("Cancelling %s for %s", build.ProjectToBuild.Name, build.TargetSys)
+	}
+	if c.Params.Get("format") == "json" {
+		return c.RenderJson(build)
+	}
+	return c.Redirect("/projects/%s/builds/%s", build.ProjectToBuild.Name, build.ID.Hex())
+}
+
 //Download the build result
 func (c BuildController) Download() revel.Result {
 	build, err := BMInstance().GetBuildByID(c.Params.Get("id"))
@@ -65,22 +105,12 @@ func (c BuildController) Download() revel.Result {
 		revel.ERROR.Println(err)
 		c.Flash.Error(err.Error())
 	}
-	if build.State > Fail {
-		var outputAddr string
-		if len(build.ProjectToBuild.Configuration.Package[build.TargetSys]) == 0 {
-			//Test for tar archive or create it
-			outputTarName := fmt.Sprintf("%s-%s~git%s.tar", build.ProjectToBuild.Name, build.TargetSys, build.Commit)
-			tarFile := fmt.Sprintf("/public/output/%s/%d/%s/%s", build.ProjectToBuild.Name, build.Date.Unix(), build.TargetSys, outputTarName)
-			if _, err := os.Stat(revel.BasePath + tarFile); os.IsNotExist(err) {
-				if err := build.CreateOutputTar(); err != nil {
-					revel.ERROR.Println(err)
-					c.Flash.Error(err.Error())
-					return c.Redirect("/projects/%s/builds/%s", build.ProjectToBuild.Name, build.ID.Hex())
-				}
-			}
-			outputAddr = tarFile
-		} else {
-			outputAddr = fmt.Sprintf("/public/output/%s/%d/%s/%s", build.ProjectToBuild.Name, build.Date.Unix(), build.TargetSys, build.ProjectToBuild.Configuration.Package[build.TargetSys])
+	if build.State.IsSuccess() {
+		outputAddr, err := build.DownloadPath()
+		if err != nil {
+			revel.ERROR.Println(err)
+			c.Flash.Error(err.Error())
+			return c.Redirect("/projects/%s/builds/%s", build.ProjectToBuild.Name, build.ID.Hex())
 		}
 		if c.Params.Get("format") == "json" {
 			return c.RenderJson(outputAddr)
@@ -93,6 +123,46 @@ func (c BuildController) Download() revel.Result {
 	return c.Redirect("/projects/%s/builds/%s", build.ProjectToBuild.Name, build.ID.Hex())
 }
 
+//LatestDownload download the result of the latest successful master build of a sys
+//FallbackSuccess builds are only used with fallback=true
+func (c BuildController) LatestDownload() revel.Result {
+	build, err := c.latestBuild()
+	if err != nil {
+		return c.NotFound(err.Error())
+	}
+	outputAddr, err := build.DownloadPath()
+	if err != nil {
+		revel.ERROR.Println(err)
+		return c.RenderError(err)
+	}
+	if c.Params.Get("format") == "json" {
+		return c.RenderJson(outputAddr)
+	}
+	return c.Redirect(outputAddr)
+}
+
+//LatestArtifact download an artifact of the latest successful master build of a sys
+func (c BuildController) LatestArtifact() revel.Result {
+	build, err := c.latestBuild()
+	if err != nil {
+		return c.NotFound(err.Error())
+	}
+	artifact := c.Params.Get("artifact")
+	if artifact != artifactManifest && !build.hasArtifact(artifact) {
+		return c.NotFound("No artifact %s in build %s", artifact, build.ID.Hex())
+	}
+	outputAddr := build.OutputPath() + "/" + artifact
+	if c.Params.Get("format") == "json" {
+		return c.RenderJson(outputAddr)
+	}
+	return c.Redirect(outputAddr)
+}
+
+func (c BuildController) latestBuild() (*Build, error) {
+	fallback, _ := strconv.ParseBool(c.Params.Get("fallback"))
+	return BMInstance().GetLatestSuccessfulBuild(c.Params.Get("project"), c.Params.Get("sys"), fallback)
+}
+
 //Deploy the built package
 func (c BuildController) Deploy() revel.Result {
 	build, err := BMInstance().GetBuildByID(c.Params.Get("id"))
@@ -100,9 +170,29 @@ func (c BuildController) Deploy() revel.Result {
 		revel.ERROR.Println(err)
 		c.Flash.Error(err.Error())
 	}
-	if build.State > Fail {
+	if build.State.IsSuccess() {
 		c.Flash.Success("Deploying %s for %s", build.ProjectToBuild.Name, build.TargetSys)
-		go BMInstance().Deploy(build)
+		//The deployment is recorded, its result shows on the deployments page
+		go BMInstance().Deploy(build, requestUser(c.Request))
+		return c.Redirect("/projects/%s/deployments", build.ProjectToBuild.Name)
 	}
 	return c.Redirect("/projects/%s/builds/%s", build.ProjectToBuild.Name, build.ID.Hex())
 }
+
+//requestUser return who sent a request: the user authenticated by the reverse proxy
+//(X-Forwarded-User or X-Remote-User) or the client address
+//The headers are only trusted with http.proxy.user_headers, anyone can send them otherwise
+func requestUser(r *revel.Request) string {
+	return requestUserFrom(r.Request, revel.Config.BoolDefault("http.proxy.user_headers", false))
+}
+
+func requestUserFrom(r *http.Request, trustHeaders bool) string {
+	if trustHeaders {
+		for _, header := range []string{"X-Forwarded-User", "X-Remote-User"} {
+			if user := r.Header.Get(header); len(user) > 0 {
+				return user
+			}
+		}
+	}
+	return r.RemoteAddr
+}
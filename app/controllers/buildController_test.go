@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		trust    bool
+		expected string
+	}{
+		{"client address", nil, true, "192.0.2.1:1234"},
+		{"forwarded user", map[string]string{"X-Forwarded-User": "alice"}, true, "alice"},
+		{"remote user", map[string]string{"X-Remote-User": "bob"}, true, "bob"},
+		{"untrusted headers", map[string]string{"X-Forwarded-User": "alice"}, false, "192.0.2.1:1234"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/projects/ring/builds/1/deploy", nil)
+		for header, value := range test.headers {
+			r.Header.Set(header, value)
+		}
+		if user := requestUserFrom(r, test.trust); user != test.expected {
+			t.Errorf("%s: got %s, expected %s", test.name, user, test.expected)
+		}
+	}
+}
@@ -2,18 +2,19 @@ package controllers
 
 import (
 	"archive/tar"
+	"bytes"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
-	"os/exec"
-	"regexp"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/revel/revel"
 
-	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -29,6 +30,10 @@ const (
 
 	Success         //4
 	FallbackSuccess //5
+
+	//Appended to keep the values already stored, use IsSuccess instead of > Fail
+	Cancelled //6
+	TimedOut  //7
 )
 
 func (s State) String() string {
@@ -45,10 +50,24 @@ func (s State) String() string {
 		return "Success"
 	case FallbackSuccess:
 		return "FallbackSuccess"
+	case Cancelled:
+		return "Cancelled"
+	case TimedOut:
+		return "TimedOut"
 	}
 	return "Unknown"
 }
 
+//IsSuccess return true if the build produced a package
+func (s State) IsSuccess() bool {
+	return s == Success || s == FallbackSuccess
+}
+
+//IsRunning return true if the build is not in a final state
+func (s State) IsRunning() bool {
+	return s == Created || s == Init || s == Building
+}
+
 //Build represent a build
 type Build struct {
 	ID                   bson.ObjectId `bson:"_id,omitempty"`
@@ -62,30 +81,75 @@ type Build struct {
 	UpdateWorkerDuration time.Duration
 	Deploy               bool
 	GitCommitID          string
+	Priority             Priority
+	QueuePosition        int64
+	Steps                []Step
+	Artifacts            []Artifact
+	Deployed             bool
+	Pruned               bool
+	Scheduled            bool
 }
 
 //IsDownloadable return true if downloadable
 func (b *Build) IsDownloadable() bool {
-	if b.State > Fail && b.Commit != "updateWorker" {
+	if b.State.IsSuccess() && !b.IsBuilderUpdate() && !b.Pruned {
 		return true
 	}
 	return false
 }
 
+//IsBuilderUpdate return true if the build only update the builder image (updateWorker)
+//or rebuild it from its Dockerfile (rebuildImage)
+func (b *Build) IsBuilderUpdate() bool {
+	return b.Commit == "updateWorker" || b.IsImageRebuild()
+}
+
+//IsImageRebuild return true if the build rebuild the builder image from its Dockerfile
+func (b *Build) IsImageRebuild() bool {
+	return b.Commit == "rebuildImage"
+}
+
+//Build kinds, used to route the notifications
+const (
+	MasterKind    = "master"
+	ReviewKind    = "review"
+	UpdateKind    = "update"
+	ScheduledKind = "scheduled"
+)
+
+//Kind of the build: update of the builder image, scheduled build, master or review ref
+//A scheduled build stays one when it is retried or moved in the queue
+func (b *Build) Kind() string {
+	switch {
+	case b.IsBuilderUpdate():
+		return UpdateKind
+	case b.Scheduled:
+		return ScheduledKind
+	case b.Commit == "master":
+		return MasterKind
+	}
+	return ReviewKind
+}
+
 //IsRetryable return true if we can retry a failed build
 func (b *Build) IsRetryable() bool {
 	if b.Commit == "master" {
 		return false
 	}
-	if b.State == Fail {
+	if b.State == Fail || b.State == Cancelled || b.State == TimedOut {
 		return true
 	}
 	return false
 }
 
+//IsCancellable return true if the build can still be stopped
+func (b *Build) IsCancellable() bool {
+	return b.State.IsRunning()
+}
+
 //IsDeployable return if the build can be deployed
 func (b *Build) IsDeployable() bool {
-	if b.State > Fail && b.Commit != "updateWorker" && b.Commit == "master" {
+	if b.State.IsSuccess() && b.Commit == "master" && !b.Pruned {
 		return true
 	}
 	return false
@@ -102,50 +166,170 @@ func (b *Build) Duration() time.Duration {
 	return b.LastUpdated.Round(time.Second).Sub(b.StartDate.Round(time.Second))
 }
 
-//CreateOutputTar the entire output folder
+//ReleaseString identify the build in package names (e.g 20150102150400~gitabc1234)
+//Review builds record the full commit id, it is shortened like the master ones
+func (b *Build) ReleaseString() string {
+	commitID := b.GitCommitID
+	if len(commitID) > 7 {
+		commitID = commitID[:7]
+	}
+	return b.Date.Format("20060102150400") + "~git" + commitID
+}
+
+//BuildInstructions of the target sys, see legacyPlaceholders
+func (b *Build) BuildInstructions() []string {
+	return legacyPlaceholders(b.ProjectToBuild.Name, b.ProjectToBuild.Configuration.BuildInstructions[b.TargetSys])
+}
+
+//UpdateInstructions of the target sys, see legacyPlaceholders
+func (b *Build) UpdateInstructions() []string {
+	return legacyPlaceholders(b.ProjectToBuild.Name, b.ProjectToBuild.Configuration.UpdateInstructions[b.TargetSys])
+}
+
+//legacyPlaceholders replace the deprecated {{REF_NUMBER}} and {{RELEASE_NUMBER}} of instructions
+//by $GOGOBUILD_REF and $GOGOBUILD_RELEASE, the shell expands them from the build environment
+func legacyPlaceholders(projectName string, instructions []string) []string {
+	replacer := strings.NewReplacer("{{REF_NUMBER}}", "$GOGOBUILD_REF", "{{RELEASE_NUMBER}}", "$GOGOBUILD_RELEASE")
+	replaced := make([]string, len(instructions))
+	for i, instruction := range instructions {
+		replaced[i] = replacer.Replace(instruction)
+		if replaced[i] != instruction {
+			revel.WARN.Printf("%s: {{REF_NUMBER}} and {{RELEASE_NUMBER}} are deprecated, use $GOGOBUILD_REF and $GOGOBUILD_RELEASE in %q", projectName, instruction)
+		}
+	}
+	return replaced
+}
+
+//Env return the environment variables given to the build instructions:
+//GOGOBUILD_REF (master, refs/changes/..., updateWorker), GOGOBUILD_COMMIT_ID (commit built, origin/master short commit id for master),
+//GOGOBUILD_RELEASE (see ReleaseString), GOGOBUILD_TARGET_SYS, GOGOBUILD_BUILD_ID and GOGOBUILD_DEPLOY (true or false)
+//followed by the Env of the target sys in .packer.json
+func (b *Build) Env() []string {
+	env := []string{
+		"GOGOBUILD_REF=" + b.Commit,
+		"GOGOBUILD_COMMIT_ID=" + b.GitCommitID,
+		"GOGOBUILD_RELEASE=" + b.ReleaseString(),
+		"GOGOBUILD_TARGET_SYS=" + b.TargetSys,
+		"GOGOBUILD_BUILD_ID=" + b.ID.Hex(),
+		"GOGOBUILD_DEPLOY=" + strconv.FormatBool(b.Deploy),
+	}
+	projectEnv := b.ProjectToBuild.Configuration.Env[b.TargetSys]
+	var keys []string
+	for key := range projectEnv {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, key+"="+projectEnv[key])
+	}
+	return env
+}
+
+//URL return the address of the build page, empty if http.addr is not configured
+func (b *Build) URL() string {
+	servAddr := revel.Config.StringDefault("http.addr", "")
+	if len(servAddr) == 0 {
+		return ""
+	}
+	port := revel.Config.StringDefault("http.port", "")
+	if len(port) > 0 {
+		port = ":" + port
+	}
+	return fmt.Sprintf("http://%s%s/projects/%s/builds/%s", servAddr, port, b.ProjectToBuild.Name, b.ID.Hex())
+}
+
+//OutputPath return the path of the build output directory relative to the application
+func (b *Build) OutputPath() string {
+	return fmt.Sprintf("/public/output/%s/%d/%s", b.ProjectToBuild.Name, b.Date.Unix(), b.TargetSys)
+}
+
+//outputTarName return the name of the archive of the build output
+func (b *Build) outputTarName() string {
+	return fmt.Sprintf("%s-%s~git%s.tar", b.ProjectToBuild.Name, b.TargetSys, b.Commit)
+}
+
+//DownloadPath return the path of the build result relative to the application
+//The Package of the sys or else an archive of the output, created on the first call
+func (b *Build) DownloadPath() (string, error) {
+	if pkg := b.ProjectToBuild.Configuration.Package[b.TargetSys]; len(pkg) > 0 {
+		return b.OutputPath() + "/" + pkg, nil
+	}
+	tarFile := b.OutputPath() + "/" + b.outputTarName()
+	if _, err := os.Stat(revel.BasePath + tarFile); os.IsNotExist(err) {
+		if err := b.CreateOutputTar(); err != nil {
+			return "", err
+		}
+	}
+	return tarFile, nil
+}
+
+//DeployLogPath return the path of the deployment log relative to the application
+func (b *Build) DeployLogPath() string {
+	return b.OutputPath() + "/" + deployLog
+}
+
+//LogsPath return the path of the build log relative to the application
+func (b *Build) LogsPath() string {
+	return b.OutputPath() + "/logs.txt"
+}
+
+//CreateOutputTar the entire output folder, subdirectories included with their relative paths
 func (b *Build) CreateOutputTar() error {
 
-	output := fmt.Sprintf("%s/public/output/%s/%d/%s/", revel.BasePath, b.ProjectToBuild.Name, b.Date.Unix(), b.TargetSys)
-	outputTarName := fmt.Sprintf("%s-%s~git%s.tar", b.ProjectToBuild.Name, b.TargetSys, b.Commit)
+	output := fmt.Sprintf("%s/public/output/%s/%d/%s", revel.BasePath, b.ProjectToBuild.Name, b.Date.Unix(), b.TargetSys)
+	outputTarName := b.outputTarName()
 	tarFile, err := os.Create(fmt.Sprintf("%s/%s", output, outputTarName))
 	if err != nil {
 		return err
 	}
+	defer tarFile.Close()
 	tw := tar.NewWriter(tarFile)
-	files, _ := ioutil.ReadDir(output)
-	for _, f := range files {
-		if f.Name() == outputTarName || f.IsDir() == true {
-			continue
+	err = filepath.Walk(output, func(path string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !f.Mode().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(output, path)
+		if err != nil {
+			return err
 		}
-		fileFD, err := os.Open(output + "/" + f.Name())
+		name = filepath.ToSlash(name)
+		if name == outputTarName {
+			return nil
+		}
+		//Only the declared artifacts and their manifest when there is one
+		if b.Artifacts != nil && name != artifactManifest && !b.hasArtifact(name) {
+			return nil
+		}
+		fileFD, err := os.Open(path)
 		if err != nil {
 			return err
 		}
 		defer fileFD.Close()
-		stat, _ := fileFD.Stat()
 		hdr := &tar.Header{
-			Name: f.Name(),
-			Mode: 0600,
-			Size: stat.Size(),
+			Name:    name,
+			Mode:    0600,
+			Size:    f.Size(),
+			ModTime: f.ModTime(),
 		}
 		if err := tw.WriteHeader(hdr); err != nil {
 			return err
 		}
-		content, _ := ioutil.ReadAll(fileFD)
-		if _, err := tw.Write(content); err != nil {
-			return err
-		}
-	}
-
-	if err := tw.Close(); err != nil {
+		_, err = io.Copy(tw, fileFD)
+		return err
+	})
+	if err != nil {
 		return err
 	}
-	return nil
+	return tw.Close()
 }
 
 //BuildManager is the build manager
 type BuildManager struct {
-	session *mgo.Session
+	store   BuildStore
+	reports *reportQueue
 }
 
 //instance of BuildManager
@@ -156,17 +340,19 @@ func BMInstance() *BuildManager {
 	if bmInstance == nil {
 		bmInstance = new(BuildManager)
 		var err error
-		bmInstance.session, err = mgo.Dial("127.0.0.1")
+		bmInstance.store, err = newBuildStore()
 		if err != nil {
-			//Pretty much dead if we don't have mongoDB anyway
+			//Pretty much dead if we can't store builds anyway
 			log.Fatal(err)
 		}
+		bmInstance.reports = newReportQueue()
+		go bmInstance.reports.run(bmInstance.reportBuild)
 	}
 	return bmInstance
 }
 
 //CreateOrReturnStatusBuild create or return status of requested build
-func (b *BuildManager) CreateOrReturnStatusBuild(projectName string, sys string, commit string, deploy bool) (*Build, error) {
+func (b *BuildManager) CreateOrReturnStatusBuild(projectName string, sys string, commit string, deploy bool, priority Priority) (*Build, error) {
 	//FIXME: This logic is flawed
 	// if commit == "master" || commit == "updateWorker" {
 	// 	return b.newBuild(projectName, sys, commit), nil
@@ -179,13 +365,41 @@ func (b *BuildManager) CreateOrReturnStatusBuild(projectName string, sys string,
 	// } else if build.State == Fail {
 	// 	b.RetryBuild(build)
 	// }
-	return b.newBuild(projectName, sys, commit, deploy), nil
+	return b.newBuild(projectName, sys, commit, reviewCommitID(projectName, commit), deploy, priority), nil
 }
 
-//NewBuild create a build and gives it to WorkerManager
-func (b *BuildManager) newBuild(projectName string, sys string, commit string, deploy bool) *Build {
+//reviewCommitID return the head commit of the open change ref, empty if ref is not one
+func reviewCommitID(projectName string, ref string) string {
+	reviewManager := PMInstance().GetProjectByName(projectName).ReviewManagerInstance
+	if reviewManager == nil || ref == "master" {
+		return ""
+	}
+	changes, err := reviewManager.GetOpenChanges()
+	if err != nil {
+		return ""
+	}
+	for _, change := range changes {
+		if change.Ref == ref {
+			return change.SHA
+		}
+	}
+	return ""
+}
+
+//CreateReviewBuild queue a build of an open change for all the target sys
+//The change SHA is recorded as GitCommitID, it is the commit the review status is posted on
+func (b *BuildManager) CreateReviewBuild(projectName string, change Change) *Build {
+	return b.newBuild(projectName, "all", change.Ref, change.SHA, false, ReviewPriority)
+}
+
+//NewBuild create a build and queue it
+//gitCommitID is the commit built, the head of origin/master when empty
+func (b *BuildManager) newBuild(projectName string, sys string, commit string, gitCommitID string, deploy bool, priority Priority) *Build {
 	project := PMInstance().GetProjectByName(projectName)
 	project.Reload()
+	if len(gitCommitID) == 0 {
+		gitCommitID = project.GetHeadCommitID()
+	}
 	var build Build
 	if sys == "all" {
 		for sysToBuild := range project.Configuration.BuildInstructions {
@@ -196,9 +410,11 @@ func (b *BuildManager) newBuild(projectName string, sys string, commit string, d
 				State:          Created,
 				Commit:         commit,
 				Deploy:         deploy,
-				GitCommitID:    project.GetHeadCommitID(),
+				GitCommitID:    gitCommitID,
+				Priority:       priority,
+				QueuePosition:  time.Now().UnixNano(),
+				Scheduled:      priority == ScheduledPriority,
 			}
-			WMInstance().Build(&build)
 			b.saveBuild(&build)
 		}
 	} else {
@@ -210,36 +426,71 @@ func (b *BuildManager) newBuild(projectName string, sys string, commit string, d
 			State:          Created,
 			Commit:         commit,
 			Deploy:         deploy,
-			GitCommitID:    project.GetHeadCommitID(),
+			GitCommitID:    gitCommitID,
+			Priority:       priority,
+			QueuePosition:  time.Now().UnixNano(),
+			Scheduled:      priority == ScheduledPriority,
 		}
-		WMInstance().Build(&build)
 		b.saveBuild(&build)
 	}
+	WMInstance().Dispatch()
 	return &build
 }
 
-//RetryBuild that failed
+//RetryBuild that failed, it is queued again with a manual priority
 func (b *BuildManager) RetryBuild(build *Build) {
 	build.ProjectToBuild.Reload()
-	WMInstance().Build(build)
+	build.State = Created
+	build.Priority = ManualPriority
+	build.QueuePosition = time.Now().UnixNano()
+	build.Steps = nil
+	build.Artifacts = nil
+	build.Pruned = false
+	//Save the whole build to keep the reloaded configuration
+	b.saveBuild(build)
+	WMInstance().Dispatch()
+}
+
+//CancelBuild stop a running build or drop it from the queue
+func (b *BuildManager) CancelBuild(build *Build) error {
+	if !build.IsCancellable() {
+		return fmt.Errorf("Build %s is %s and can't be cancelled", build.ID.Hex(), build.State)
+	}
+	return WMInstance().Cancel(build)
 }
 
 //GetBuildsByProjects get list of projects builds
 func (b *BuildManager) GetBuildsByProjects(projectName string) ([]Build, error) {
-	c := b.session.DB("gogobuild").C("builds")
-	var buildList []Build
-	err := c.Find(bson.M{"projecttobuild.name": projectName}).Sort("-date").All(&buildList)
+	buildList, err := b.store.GetBuildsByProject(projectName)
 	if err != nil {
 		log.Println(err)
 	}
 	return buildList, err
 }
 
+//GetLatestSuccessfulBuild return the most recent successful master build of a project for sys
+//FallbackSuccess builds are only considered with allowFallback
+func (b *BuildManager) GetLatestSuccessfulBuild(projectName string, sys string, allowFallback bool) (*Build, error) {
+	buildList, err := b.store.GetBuildsByProject(projectName)
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	for i := range buildList {
+		build := &buildList[i]
+		if build.Commit != "master" || build.TargetSys != sys || build.Pruned {
+			continue
+		}
+		if build.State == Success || (allowFallback && build.State == FallbackSuccess) {
+			return build, nil
+		}
+	}
+	return nil, ErrBuildNotFound
+}
+
 //GetBuildByID return a build by it's id
 func (b *BuildManager) GetBuildByID(id string) (*Build, error) {
-	c := b.session.DB("gogobuild").C("builds")
-	var build = new(Build)
-	err := c.FindId(bson.ObjectIdHex(id)).One(build)
+	build, err := b.store.GetBuildByID(id)
 	if err != nil {
 		log.Println(err)
 	}
@@ -248,51 +499,115 @@ func (b *BuildManager) GetBuildByID(id string) (*Build, error) {
 
 //UpdateBuild in DB
 func (b *BuildManager) UpdateBuild(build *Build) error {
-	c := b.session.DB("gogobuild").C("builds")
-	err := c.Update(bson.M{"_id": build.ID},
-		bson.M{"$set": bson.M{"state": build.State, "lastupdated": time.Now(), "updateworkerduration": build.UpdateWorkerDuration, "startdate": build.StartDate}})
+	err := b.store.UpdateBuild(build)
 	if err != nil {
 		log.Println(err)
 	}
-	if build.State > Fail && build.Deploy == true {
-		b.Deploy(build)
-	} else if build.State == Fail && build.Deploy == true {
-		MMInstance().SendBuildFailedMail(*build)
+	//Notifications, deployments and reports wait on remote services, they don't hold the worker.
+	//Each gets its own copy of the build, the worker keeps updating it.
+	if !build.State.IsRunning() {
+		finished := *build
+		go func() {
+			NMInstance().NotifyBuild(&finished)
+			if finished.State.IsSuccess() && finished.Deploy == true {
+				b.Deploy(&finished, "auto")
+			}
+		}()
+	}
+	if !build.State.IsRunning() {
+		b.reports.push(build)
 	}
 	return err
 }
 
-//Deploy the built package
-func (b *BuildManager) Deploy(build *Build) {
-	output := fmt.Sprintf("%s/public/output/%s/%d/%s/", revel.BasePath, build.ProjectToBuild.Name, build.Date.Unix(), build.TargetSys)
+//StartBuild move a build to the Building state and report it started to the review system
+//The updates made while it builds (steps) are not reported
+func (b *BuildManager) StartBuild(build *Build) error {
+	build.State = Building
+	err := b.UpdateBuild(build)
+	b.reports.push(build)
+	return err
+}
 
-	localTmpFolder, _ := revel.Config.String("local_tmp_folder")
-	tmpFolder := fmt.Sprintf("%s%s/packages/%s", localTmpFolder, build.ProjectToBuild.Name, strings.Replace(build.TargetSys, "_i386", "", -1))
+//reportBuild send the build state to the project review system, when it starts and once finished
+func (b *BuildManager) reportBuild(build *Build) {
+	reviewManager := PMInstance().GetProjectByName(build.ProjectToBuild.Name).ReviewManagerInstance
+	if reviewManager == nil {
+		return
+	}
+	if err := reviewManager.ReportBuild(build); err != nil {
+		revel.WARN.Println(err)
+	}
+}
 
-	exec.Command("rm", "-Rf", tmpFolder).Run()
-	exec.Command("mkdir", "-p", tmpFolder).Run()
+//Deploy the build to the deploy target of its sys, by is who asked for it
+//The deployment is recorded with its output, also kept in deploy.txt in the build output directory
+func (b *BuildManager) Deploy(build *Build, by string) *Deployment {
+	deployment := newDeployment(build, by)
+	if err := b.store.SaveDeployment(deployment); err != nil {
+		log.Println(err)
+	}
+	var output bytes.Buffer
+	err := b.deploy(build, deployment, &output)
+	deployment.finish(err, &output)
+	if err := b.store.SaveDeployment(deployment); err != nil {
+		log.Println(err)
+	}
+	if err != nil {
+		revel.ERROR.Printf("Deploy of build %s failed: %s", build.ID.Hex(), err)
+		NMInstance().NotifyDeploy(build, deployment, err)
+		return deployment
+	}
+	//Deployed builds can be kept by the retention rules
+	build.Deployed = true
+	b.store.UpdateBuild(build)
+	NMInstance().NotifyDeploy(build, deployment, nil)
+	return deployment
+}
+
+func (b *BuildManager) deploy(build *Build, deployment *Deployment, output io.Writer) error {
+	target, err := build.ProjectToBuild.Configuration.GetDeployTarget(build.TargetSys)
+	if err != nil {
+		return err
+	}
+	deployment.Target = target
+	deployer, err := newDeployer(target)
+	if err != nil {
+		return err
+	}
+	outputDir := revel.BasePath + build.OutputPath()
+	logFile, err := os.Create(filepath.Join(outputDir, deployLog))
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	out := io.MultiWriter(logFile, output)
 
-	//Copy output to tmp_folder
-	files, _ := ioutil.ReadDir(output)
-	date := build.Date.Format("20060102150400")
-	re := regexp.MustCompile("(_amd64|_i386|\\.x86_64|\\.i686)?(\\.exe)$")
-	for _, f := range files {
-		exec.Command("cp", output+f.Name(), tmpFolder+"/"+re.ReplaceAllString(f.Name(), "-"+date+"~git"+build.GitCommitID+"$1$2")).Run()
+	files, err := build.deployFiles(outputDir)
+	if err == nil {
+		fmt.Fprintf(out, "Deploying %d files of %s for %s (%s)\n", len(files), build.ProjectToBuild.Name, build.TargetSys, target.Type)
+		err = deployer.Deploy(build, outputDir, files, out)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "\nDEPLOY FAILED: %s\n", err)
+		return err
 	}
+	fmt.Fprint(out, "\nDEPLOY SUCCESS\n")
+	return nil
+}
 
-	//Exec Deploy Script
-	cmd := exec.Command("/bin/bash", fmt.Sprintf("%s/scripts/%s", revel.BasePath, build.ProjectToBuild.Configuration.DeployScript), build.ProjectToBuild.Name)
-	out, err := cmd.CombinedOutput()
-	revel.WARN.Println(string(out))
+//GetDeploymentsByProject return the deployments of a project, most recent first
+func (b *BuildManager) GetDeploymentsByProject(projectName string) ([]Deployment, error) {
+	deployments, err := b.store.GetDeploymentsByProject(projectName)
 	if err != nil {
-		revel.ERROR.Println(err.Error())
+		log.Println(err)
 	}
+	return deployments, err
 }
 
 //SaveBuild in DB
 func (b *BuildManager) saveBuild(build *Build) error {
-	c := b.session.DB("gogobuild").C("builds")
-	err := c.Insert(build)
+	err := b.store.SaveBuild(build)
 	if err != nil {
 		log.Println(err)
 	}
@@ -300,10 +615,29 @@ func (b *BuildManager) saveBuild(build *Build) error {
 }
 
 //BuildMaintenance should be called in case build were not updated to there final state
-//(e.g at start since no build should be in created, init or building state)
+//(e.g at start since no build should be in init or building state)
+//Builds that still have a container are reattached, the others are failed.
+//Queued builds (created state) are started again, running deployments are failed.
 func (b *BuildManager) BuildMaintenance() error {
-	c := b.session.DB("gogobuild").C("builds")
-	_, err := c.UpdateAll(bson.M{"state": bson.M{"$in": []State{Created, Init, Building}}},
-		bson.M{"$set": bson.M{"state": Fail}})
-	return err
+	if err := failUnfinishedDeployments(b.store); err != nil {
+		return err
+	}
+
+	builds, err := b.store.GetUnfinishedBuilds()
+	if err != nil {
+		return err
+	}
+	defer WMInstance().Dispatch()
+	for i := range builds {
+		build := &builds[i]
+		if build.State == Created {
+			continue
+		}
+		if err := WMInstance().Reattach(build); err != nil {
+			revel.WARN.Printf("Build %s not reattached: %s", build.ID.Hex(), err)
+			build.State = Fail
+			b.store.UpdateBuild(build)
+		}
+	}
+	return nil
 }
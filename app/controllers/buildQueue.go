@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"errors"
+	"sort"
+)
+
+//Priority of a queued build, the lowest start first
+type Priority int
+
+//Priority enum
+const (
+	ManualPriority    Priority = iota //0
+	MasterPriority                    //1
+	ReviewPriority                    //2
+	ScheduledPriority                 //3
+)
+
+func (p Priority) String() string {
+	switch p {
+	case ManualPriority:
+		return "Manual"
+	case MasterPriority:
+		return "Master"
+	case ReviewPriority:
+		return "Review"
+	case ScheduledPriority:
+		return "Scheduled"
+	}
+	return "Unknown"
+}
+
+//buildsByQueue sort builds by priority then by position in the queue
+type buildsByQueue []Build
+
+func (b buildsByQueue) Len() int      { return len(b) }
+func (b buildsByQueue) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b buildsByQueue) Less(i, j int) bool {
+	if b[i].Priority != b[j].Priority {
+		return b[i].Priority < b[j].Priority
+	}
+	return b[i].QueuePosition < b[j].QueuePosition
+}
+
+//GetQueue return the builds waiting for a worker, next to start first
+func (b *BuildManager) GetQueue() ([]Build, error) {
+	builds, err := b.store.GetUnfinishedBuilds()
+	if err != nil {
+		return nil, err
+	}
+	var queue []Build
+	for _, build := range builds {
+		if build.State == Created {
+			queue = append(queue, build)
+		}
+	}
+	sort.Sort(buildsByQueue(queue))
+	return queue, nil
+}
+
+//GetRunningBuilds return the builds being built
+func (b *BuildManager) GetRunningBuilds() ([]Build, error) {
+	builds, err := b.store.GetUnfinishedBuilds()
+	if err != nil {
+		return nil, err
+	}
+	var running []Build
+	for _, build := range builds {
+		if build.State != Created {
+			running = append(running, build)
+		}
+	}
+	sort.Sort(buildsByDate(running))
+	return running, nil
+}
+
+//MoveQueuedBuild move a queued build one place up (sooner) or down in the queue
+//The build swaps its QueuePosition with its neighbour, it only moves among the builds of its priority
+func (b *BuildManager) MoveQueuedBuild(id string, up bool) error {
+	queue, err := b.GetQueue()
+	if err != nil {
+		return err
+	}
+	i := -1
+	for k := range queue {
+		if queue[k].ID.Hex() == id {
+			i = k
+			break
+		}
+	}
+	if i < 0 {
+		return errors.New("Build is not queued")
+	}
+	j := i + 1
+	if up {
+		j = i - 1
+	}
+	if j < 0 || j >= len(queue) || queue[j].Priority != queue[i].Priority {
+		return nil
+	}
+	queue[i].QueuePosition, queue[j].QueuePosition = queue[j].QueuePosition, queue[i].QueuePosition
+	if queue[i].QueuePosition == queue[j].QueuePosition {
+		//Builds created in the same nanosecond, still keep them apart
+		if up {
+			queue[i].QueuePosition--
+		} else {
+			queue[i].QueuePosition++
+		}
+	}
+	if err := b.store.UpdateBuild(&queue[i]); err != nil {
+		return err
+	}
+	return b.store.UpdateBuild(&queue[j])
+}
@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//stepMarker prefix the lines printed by the build script around each instruction
+//They are taken out of the log and turned into Steps
+const stepMarker = "##gogobuild-step"
+
+//Step is one build instruction
+//LogStart and LogEnd are the byte range of its output in the build log
+type Step struct {
+	Index     int
+	Command   string
+	Image     string
+	State     State
+	ExitCode  int
+	StartDate time.Time
+	EndDate   time.Time
+	LogStart  int64
+	LogEnd    int64
+}
+
+//Number of the step, starting at 1 like in the log
+func (s *Step) Number() int {
+	return s.Index + 1
+}
+
+//Duration of the step
+func (s *Step) Duration() time.Duration {
+	if s.EndDate.IsZero() {
+		return time.Since(s.StartDate).Round(time.Second)
+	}
+	return s.EndDate.Sub(s.StartDate).Round(time.Second)
+}
+
+//HasFailed return true if the step is the one that broke the build
+func (s *Step) HasFailed() bool {
+	return s.State == Fail || s.State == Cancelled || s.State == TimedOut
+}
+
+//stepScript run the instructions one after the other like a && chain
+//Each instruction is surrounded by markers giving its index and exit code
+//Instructions share the same shell so cd and export still apply to the next ones
+func stepScript(instructions []string) string {
+	var script bytes.Buffer
+	for i, instruction := range instructions {
+		fmt.Fprintf(&script, "echo '%s start %d'\n", stepMarker, i)
+		script.WriteString(instruction + "\n")
+		fmt.Fprintf(&script, "gogobuild_rc=$?\necho \"%s end %d $gogobuild_rc\"\n", stepMarker, i)
+		script.WriteString("[ $gogobuild_rc -eq 0 ] || exit $gogobuild_rc\n")
+	}
+	return script.String()
+}
+
+//stepWriter copy the build output to the log file and record the steps
+//on the build as the markers go by
+type stepWriter struct {
+	build    *Build
+	log      *os.File
+	image    string
+	commands []string
+	line     []byte
+
+	//update save the build when a step starts or ends, BMInstance().UpdateBuild when nil
+	update func(build *Build) error
+}
+
+func (s *stepWriter) Write(p []byte) (int, error) {
+	s.line = append(s.line, p...)
+	for {
+		end := bytes.IndexByte(s.line, '\n')
+		if end < 0 {
+			return len(p), nil
+		}
+		if err := s.writeLine(s.line[:end+1]); err != nil {
+			return len(p), err
+		}
+		s.line = s.line[end+1:]
+	}
+}
+
+//Flush write what is left of an unfinished last line
+func (s *stepWriter) Flush() error {
+	if len(s.line) == 0 {
+		return nil
+	}
+	err := s.writeLine(s.line)
+	s.line = nil
+	return err
+}
+
+func (s *stepWriter) writeLine(line []byte) error {
+	i := bytes.Index(line, []byte(stepMarker))
+	if i < 0 {
+		_, err := s.log.Write(line)
+		return err
+	}
+	//Lines are prefixed by the docker timestamp, shell output is not
+	prefix := line[:i]
+	at := time.Now()
+	if space := bytes.IndexByte(prefix, ' '); space > 0 {
+		if t, err := time.Parse(time.RFC3339Nano, string(prefix[:space])); err == nil {
+			at = t
+			prefix = prefix[space+1:]
+		}
+	}
+	//Output of the previous instruction without a final new line
+	if len(prefix) > 0 {
+		if _, err := fmt.Fprintf(s.log, "%s\n", prefix); err != nil {
+			return err
+		}
+	}
+
+	fields := strings.Fields(string(line[i+len(stepMarker):]))
+	if len(fields) < 2 {
+		return nil
+	}
+	index, err := strconv.Atoi(fields[1])
+	if err != nil || index < 0 || index >= len(s.commands) {
+		return nil
+	}
+	switch fields[0] {
+	case "start":
+		return s.start(index, at)
+	case "end":
+		exitCode := -1
+		if len(fields) > 2 {
+			exitCode, _ = strconv.Atoi(fields[2])
+		}
+		return s.end(index, exitCode, at)
+	}
+	return nil
+}
+
+func (s *stepWriter) start(index int, at time.Time) error {
+	build := s.build
+	//Already recorded before a server restart
+	if s.find(index) != nil {
+		return nil
+	}
+	if _, err := fmt.Fprintf(s.log, "\n---STEP %d: %s---\n", index+1, s.commands[index]); err != nil {
+		return err
+	}
+	offset, err := s.log.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	build.Steps = append(build.Steps, Step{
+		Index:     index,
+		Command:   s.commands[index],
+		Image:     s.image,
+		State:     Building,
+		StartDate: at,
+		LogStart:  offset,
+	})
+	return s.save()
+}
+
+func (s *stepWriter) end(index int, exitCode int, at time.Time) error {
+	step := s.find(index)
+	if step == nil || !step.State.IsRunning() {
+		return nil
+	}
+	offset, err := s.log.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	step.EndDate = at
+	step.ExitCode = exitCode
+	step.LogEnd = offset
+	if exitCode == 0 {
+		step.State = Success
+	} else {
+		step.State = Fail
+	}
+	return s.save()
+}
+
+func (s *stepWriter) save() error {
+	if s.update == nil {
+		return BMInstance().UpdateBuild(s.build)
+	}
+	return s.update(s.build)
+}
+
+//close the step left running when the container stopped
+func (s *stepWriter) close(state State) error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	offset, err := s.log.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	for i := range s.build.Steps {
+		step := &s.build.Steps[i]
+		if step.Image == s.image && step.State.IsRunning() {
+			step.State = state
+			step.ExitCode = -1
+			step.EndDate = time.Now()
+			step.LogEnd = offset
+		}
+	}
+	return nil
+}
+
+func (s *stepWriter) find(index int) *Step {
+	steps := s.build.Steps
+	for i := range steps {
+		if steps[i].Image == s.image && steps[i].Index == index {
+			return &steps[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+//newTestStepWriter write the log of build to a temporary file, updates are counted
+func newTestStepWriter(t *testing.T, commands ...string) (*stepWriter, *int) {
+	log, err := ioutil.TempFile("", "gogobuild-steps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updates := new(int)
+	return &stepWriter{
+		build:    &Build{},
+		log:      log,
+		image:    "host",
+		commands: commands,
+		update: func(build *Build) error {
+			*updates++
+			return nil
+		},
+	}, updates
+}
+
+func closeTestStepWriter(s *stepWriter) {
+	s.log.Close()
+	os.Remove(s.log.Name())
+}
+
+//stepLog return the part of the log of a step
+func stepLog(t *testing.T, s *stepWriter, step Step) string {
+	data, err := ioutil.ReadFile(s.log.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if step.LogStart > step.LogEnd || step.LogEnd > int64(len(data)) {
+		t.Fatalf("step %d: invalid range %d-%d of %d bytes", step.Index, step.LogStart, step.LogEnd, len(data))
+	}
+	return string(data[step.LogStart:step.LogEnd])
+}
+
+func TestStepWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		//State, exit code and log of each step
+		states []State
+		codes  []int
+		logs   []string
+		//Whole log once closed as cancelled
+		log string
+	}{
+		{
+			name:   "whole lines",
+			writes: []string{"##gogobuild-step start 0\n", "one\n", "##gogobuild-step end 0 0\n", "##gogobuild-step start 1\n", "two\n", "##gogobuild-step end 1 2\n"},
+			states: []State{Success, Fail},
+			codes:  []int{0, 2},
+			logs:   []string{"one\n", "two\n"},
+			log:    "\n---STEP 1: make---\none\n\n---STEP 2: make test---\ntwo\n",
+		},
+		{
+			name:   "markers split across writes",
+			writes: []string{"##gogobuild-st", "ep start 0\no", "ne\n##gogo", "build-step end 0 0", "\n"},
+			states: []State{Success},
+			codes:  []int{0},
+			logs:   []string{"one\n"},
+			log:    "\n---STEP 1: make---\none\n",
+		},
+		{
+			name:   "output without a final new line",
+			writes: []string{"##gogobuild-step start 0\n", "no new line##gogobuild-step end 0 0\n"},
+			states: []State{Success},
+			codes:  []int{0},
+			logs:   []string{"no new line\n"},
+			log:    "\n---STEP 1: make---\nno new line\n",
+		},
+		{
+			name:   "docker timestamps",
+			writes: []string{"2024-03-01T12:30:00.000000000Z ##gogobuild-step start 0\n", "2024-03-01T12:30:00.000000000Z one\n", "2024-03-01T12:31:00.000000000Z ##gogobuild-step end 0 0\n"},
+			states: []State{Success},
+			codes:  []int{0},
+			logs:   []string{"2024-03-01T12:30:00.000000000Z one\n"},
+			log:    "\n---STEP 1: make---\n2024-03-01T12:30:00.000000000Z one\n",
+		},
+		{
+			name:   "missing end marker and final partial line",
+			writes: []string{"##gogobuild-step start 0\n", "one\n", "##gogobuild-step end 0 0\n", "##gogobuild-step start 1\n", "killed in the mid"},
+			states: []State{Success, Cancelled},
+			codes:  []int{0, -1},
+			logs:   []string{"one\n", "killed in the mid"},
+			log:    "\n---STEP 1: make---\none\n\n---STEP 2: make test---\nkilled in the mid",
+		},
+		{
+			name:   "unknown markers are dropped",
+			writes: []string{"##gogobuild-step start 7\n", "##gogobuild-step\n", "##gogobuild-step finish 0\n", "out\n"},
+			log:    "out\n",
+		},
+	}
+	for _, test := range tests {
+		s, _ := newTestStepWriter(t, "make", "make test")
+		for _, write := range test.writes {
+			if n, err := s.Write([]byte(write)); err != nil || n != len(write) {
+				t.Fatalf("%s: got %d, %v writing %q", test.name, n, err, write)
+			}
+		}
+		if err := s.close(Cancelled); err != nil {
+			t.Fatal(err)
+		}
+		if len(s.build.Steps) != len(test.states) {
+			t.Errorf("%s: got %d steps, expected %d", test.name, len(s.build.Steps), len(test.states))
+			closeTestStepWriter(s)
+			continue
+		}
+		for i, step := range s.build.Steps {
+			if step.State != test.states[i] || step.ExitCode != test.codes[i] {
+				t.Errorf("%s: step %d is %s (%d), expected %s (%d)", test.name, i, step.State, step.ExitCode, test.states[i], test.codes[i])
+			}
+			if log := stepLog(t, s, step); log != test.logs[i] {
+				t.Errorf("%s: step %d log is %q, expected %q", test.name, i, log, test.logs[i])
+			}
+		}
+		data, _ := ioutil.ReadFile(s.log.Name())
+		if string(data) != test.log {
+			t.Errorf("%s: got log %q, expected %q", test.name, data, test.log)
+		}
+		closeTestStepWriter(s)
+	}
+}
+
+func TestStepScript(t *testing.T) {
+	commands := []string{"echo one", "cd / && export STEP=two", "echo $STEP from $PWD", "false", "echo never"}
+	s, updates := newTestStepWriter(t, commands...)
+	defer closeTestStepWriter(s)
+
+	cmd := exec.Command("bash", "-c", stepScript(commands))
+	cmd.Stdout = s
+	err := cmd.Run()
+	if exitCode(err) != 1 {
+		t.Errorf("got %v, expected the exit code of false", err)
+	}
+	if err := s.close(Fail); err != nil {
+		t.Fatal(err)
+	}
+
+	states := []State{Success, Success, Success, Fail}
+	logs := []string{"one\n", "", "two from /\n", ""}
+	if len(s.build.Steps) != len(states) {
+		t.Fatalf("got %d steps, expected %d", len(s.build.Steps), len(states))
+	}
+	for i, step := range s.build.Steps {
+		if step.State != states[i] || step.Command != commands[i] || stepLog(t, s, step) != logs[i] {
+			t.Errorf("step %d: got %s %q with log %q", i, step.State, step.Command, stepLog(t, s, step))
+		}
+	}
+	if *updates != 2*len(states) {
+		t.Errorf("got %d updates, expected one at the start and end of each step", *updates)
+	}
+	data, _ := ioutil.ReadFile(s.log.Name())
+	if strings.Contains(string(data), stepMarker) || strings.Contains(string(data), "never") {
+		t.Errorf("unexpected log %q", data)
+	}
+}
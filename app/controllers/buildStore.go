@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/revel/revel"
+)
+
+//ErrBuildNotFound is returned by a BuildStore when no build match the request
+var ErrBuildNotFound = errors.New("not found")
+
+//BuildStore interface
+//A BuildStore persists the builds and their deployments, the backend is selected by build.store in app.conf
+type BuildStore interface {
+	Init(url string) error
+	SaveBuild(build *Build) error
+	UpdateBuild(build *Build) error
+	GetBuildByID(id string) (*Build, error)
+	GetBuildsByProject(projectName string) ([]Build, error)
+	GetUnfinishedBuilds() ([]Build, error)
+	SaveDeployment(deployment *Deployment) error
+	GetDeploymentsByProject(projectName string) ([]Deployment, error)
+	GetUnfinishedDeployments() ([]Deployment, error)
+}
+
+//newBuildStore return the BuildStore configured in app.conf
+//build.store is mongodb (default) or bolt, build.store.url the mongodb dial url or the bolt database file
+func newBuildStore() (BuildStore, error) {
+	var store BuildStore
+	var url string
+
+	backend := revel.Config.StringDefault("build.store", "mongodb")
+	switch backend {
+	case "mongodb":
+		store = new(MongoStore)
+		url = revel.Config.StringDefault("build.store.url", "127.0.0.1")
+	case "bolt":
+		store = new(BoltStore)
+		url = revel.Config.StringDefault("build.store.url", "gogobuild.db")
+		if !filepath.IsAbs(url) {
+			url = filepath.Join(revel.BasePath, url)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown build.store %s", backend)
+	}
+
+	if err := store.Init(url); err != nil {
+		return nil, fmt.Errorf("Can't open build.store %s (%s): %s", backend, url, err)
+	}
+	return store, nil
+}
+
+//buildsByDate sort builds from the most recent to the oldest
+type buildsByDate []Build
+
+func (b buildsByDate) Len() int           { return len(b) }
+func (b buildsByDate) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b buildsByDate) Less(i, j int) bool { return b[i].Date.After(b[j].Date) }
+
+//deploymentsByDate sort deployments from the most recent to the oldest
+type deploymentsByDate []Deployment
+
+func (d deploymentsByDate) Len() int           { return len(d) }
+func (d deploymentsByDate) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d deploymentsByDate) Less(i, j int) bool { return d[i].StartDate.After(d[j].StartDate) }
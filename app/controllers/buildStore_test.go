@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func newTestBoltStore(t *testing.T) (*BoltStore, func()) {
+	dir, err := ioutil.TempDir("", "gogobuild-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := new(BoltStore)
+	if err := store.Init(filepath.Join(dir, "gogobuild.db")); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return store, func() {
+		store.db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+//testBuildStore save, update and query the builds of projectName, a project only the test use
+func testBuildStore(t *testing.T, store BuildStore, projectName string) {
+	now := time.Now().Truncate(time.Millisecond)
+	newBuild := func(age time.Duration, state State) *Build {
+		build := &Build{ID: bson.NewObjectId(), Date: now.Add(-age), TargetSys: "win32", Commit: "master", State: state}
+		build.ProjectToBuild.Name = projectName
+		return build
+	}
+	oldest := newBuild(2*time.Hour, Success)
+	running := newBuild(time.Hour, Building)
+	queued := newBuild(0, Created)
+	other := newBuild(0, Created)
+	other.ProjectToBuild.Name = projectName + "-other"
+	for _, build := range []*Build{running, oldest, queued, other} {
+		if err := store.SaveBuild(build); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	running.State = Fail
+	running.Steps = []Step{{Command: "make", ExitCode: 2}}
+	running.Artifacts = []Artifact{{Name: "ring.exe", Size: 42}}
+	running.QueuePosition = 7
+	running.Pruned = true
+	running.Commit = "not updated"
+	if err := store.UpdateBuild(running); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.GetBuildByID(running.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.State != Fail || len(stored.Steps) != 1 || stored.Steps[0].ExitCode != 2 || len(stored.Artifacts) != 1 ||
+		stored.QueuePosition != 7 || !stored.Pruned || stored.ProjectToBuild.Name != projectName {
+		t.Errorf("got %+v, expected the updated build", stored)
+	}
+	if stored.Commit != "master" {
+		t.Errorf("got commit %s, UpdateBuild only update the state and flags", stored.Commit)
+	}
+
+	builds, err := store.GetBuildsByProject(projectName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builds) != 3 || builds[0].ID != queued.ID || builds[1].ID != running.ID || builds[2].ID != oldest.ID {
+		t.Errorf("got %v, expected the builds of the project, most recent first", builds)
+	}
+
+	unfinished, err := store.GetUnfinishedBuilds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[bson.ObjectId]bool)
+	for _, build := range unfinished {
+		found[build.ID] = true
+	}
+	if !found[queued.ID] || !found[other.ID] || found[running.ID] || found[oldest.ID] {
+		t.Errorf("got %v, expected the queued builds only", unfinished)
+	}
+
+	for _, id := range []string{bson.NewObjectId().Hex(), "not an id"} {
+		if _, err := store.GetBuildByID(id); err != ErrBuildNotFound {
+			t.Errorf("%s: got %v, expected ErrBuildNotFound", id, err)
+		}
+	}
+	if err := store.UpdateBuild(newBuild(0, Fail)); err == nil {
+		t.Error("expected an error updating a build never saved")
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	store, cleanup := newTestBoltStore(t)
+	defer cleanup()
+	testBuildStore(t, store, "ring")
+}
+
+//TestMongoStore run against the server of GOGOBUILD_TEST_MONGODB (e.g 127.0.0.1), skipped without it
+func TestMongoStore(t *testing.T) {
+	url := os.Getenv("GOGOBUILD_TEST_MONGODB")
+	if len(url) == 0 {
+		t.Skip("GOGOBUILD_TEST_MONGODB is not set")
+	}
+	store := new(MongoStore)
+	if err := store.Init(url); err != nil {
+		t.Fatal(err)
+	}
+	defer store.session.Close()
+	projectName := "gogobuild-test-" + bson.NewObjectId().Hex()
+	defer store.builds().RemoveAll(bson.M{"projecttobuild.name": bson.M{"$in": []string{projectName, projectName + "-other"}}})
+	testBuildStore(t, store, projectName)
+}
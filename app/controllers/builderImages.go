@@ -0,0 +1,274 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+)
+
+//builderImageTagFormat is the time layout of the tag given to each builder image
+//The latest and fallback tags point to one of them
+const builderImageTagFormat = "20060102-150405"
+
+//pinnedTag point to the image picked with PinBuilderImage, its docker context is not checked
+//while it is the fallback image (see DockerWorker.Run)
+const pinnedTag = "pinned"
+
+//BuilderImage is a version of the builder image of a project sys
+type BuilderImage struct {
+	Host     string
+	Tag      string
+	ID       string
+	Created  time.Time
+	Size     int64
+	Latest   bool
+	Fallback bool
+	Pinned   bool
+}
+
+//builderRepository return the docker repository of the builder images of a project sys
+func builderRepository(projectName string, sys string) string {
+	return fmt.Sprintf("gogobuild/%s_%s", projectName, strings.ToLower(sys))
+}
+
+//newBuilderImageTag return the tag of an image committed now
+func newBuilderImageTag() string {
+	return time.Now().Format(builderImageTagFormat)
+}
+
+//ListBuilderImages return the history of the builder image of a project sys on every docker host,
+//most recent first
+func ListBuilderImages(projectName string, sys string) ([]BuilderImage, error) {
+	return HPInstance().builderImages(builderRepository(projectName, sys))
+}
+
+//builderImages list the images of repository on the hosts, an error is returned if none of them answered
+func (p *DockerHostPool) builderImages(repository string) ([]BuilderImage, error) {
+	var history []BuilderImage
+	var lastErr error
+	answered := false
+	for _, host := range p.hosts {
+		images, err := listBuilderImages(host.probe, repository)
+		if err != nil {
+			host.setHealth(err)
+			lastErr = err
+			continue
+		}
+		answered = true
+		for i := range images {
+			images[i].Host = host.Name
+		}
+		history = append(history, images...)
+	}
+	if !answered && lastErr != nil {
+		return nil, fmt.Errorf("No docker host listed the images of %s: %s", repository, lastErr)
+	}
+	sort.SliceStable(history, func(i, j int) bool { return history[i].Tag > history[j].Tag })
+	return history, nil
+}
+
+func listBuilderImages(client *docker.Client, repository string) ([]BuilderImage, error) {
+	images, err := client.ListImages(docker.ListImagesOptions{Filter: repository})
+	if err != nil {
+		return nil, err
+	}
+	var history []BuilderImage
+	for _, image := range images {
+		var tags []string
+		latest, fallback, pinned := false, false, false
+		for _, repoTag := range image.RepoTags {
+			if !strings.HasPrefix(repoTag, repository+":") {
+				continue
+			}
+			switch tag := strings.TrimPrefix(repoTag, repository+":"); tag {
+			case "latest":
+				latest = true
+			case "fallback":
+				fallback = true
+			case pinnedTag:
+				pinned = true
+			default:
+				tags = append(tags, tag)
+			}
+		}
+		for _, tag := range tags {
+			history = append(history, BuilderImage{
+				Tag:      tag,
+				ID:       image.ID,
+				Created:  time.Unix(image.Created, 0),
+				Size:     image.Size,
+				Latest:   latest,
+				Fallback: fallback,
+				Pinned:   pinned,
+			})
+		}
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].Tag > history[j].Tag })
+	return history, nil
+}
+
+//pruneBuilderImages remove the versions of a builder image older than the keep most recent ones
+//The latest, fallback and pinned images are never removed, keep 0 or less keep them all
+func pruneBuilderImages(client *docker.Client, repository string, keep int) ([]string, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+	history, err := listBuilderImages(client, repository)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, tag := range prunedBuilderTags(history, keep) {
+		if err := client.RemoveImage(repository + ":" + tag); err != nil {
+			return removed, err
+		}
+		removed = append(removed, tag)
+	}
+	return removed, nil
+}
+
+//prunedBuilderTags return the tags of history (most recent first) past the keep first ones,
+//except the latest, fallback and pinned images
+func prunedBuilderTags(history []BuilderImage, keep int) []string {
+	var tags []string
+	for i, image := range history {
+		if i < keep || image.Latest || image.Fallback || image.Pinned {
+			continue
+		}
+		tags = append(tags, image.Tag)
+	}
+	return tags
+}
+
+//PinBuilderImage make a version of the builder image the fallback (and latest) image
+//of a project sys on a docker host. Builds of refs use it and the next update starts from it.
+//It is also tagged pinned so a docker context change does not rebuild it over the choice.
+func PinBuilderImage(projectName string, sys string, hostName string, tag string) error {
+	host, err := HPInstance().GetHost(hostName)
+	if err != nil {
+		return err
+	}
+	repository := builderRepository(projectName, sys)
+	if _, err := host.probe.InspectImage(repository + ":" + tag); err != nil {
+		return fmt.Errorf("No image %s:%s on %s: %s", repository, tag, hostName, err)
+	}
+	for _, alias := range []string{"fallback", "latest", pinnedTag} {
+		err := host.probe.TagImage(repository+":"+tag, docker.TagImageOptions{Repo: repository, Tag: alias, Force: true})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//RollbackBuilderImage pin the version preceding the current fallback image on a docker host
+//and return its tag
+func RollbackBuilderImage(projectName string, sys string, hostName string) (string, error) {
+	host, err := HPInstance().GetHost(hostName)
+	if err != nil {
+		return "", err
+	}
+	history, err := listBuilderImages(host.probe, builderRepository(projectName, sys))
+	if err != nil {
+		return "", err
+	}
+	for i, image := range history {
+		if !image.Fallback {
+			continue
+		}
+		//Skip the other tags of the same image
+		for _, previous := range history[i+1:] {
+			if previous.ID != image.ID {
+				return previous.Tag, PinBuilderImage(projectName, sys, hostName, previous.Tag)
+			}
+		}
+		break
+	}
+	return "", errors.New("No previous image to roll back to")
+}
+
+//contextHash return the SHA-256 of a docker build context and of its build args
+//Files excluded by the .dockerignore of the context are not part of it
+func contextHash(contextDir string, buildArgs []docker.BuildArg) (string, error) {
+	ignored, err := readDockerignore(contextDir)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	err = filepath.Walk(contextDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(contextDir, path)
+		if err != nil || name == "." {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		skip, err := isDockerignored(ignored, name)
+		if err != nil {
+			return err
+		}
+		if skip {
+			//A ! pattern may include back a file of an ignored directory
+			if info.IsDir() && !ignored.Exclusions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		fmt.Fprintf(hash, "%s\x00%o\x00", name, info.Mode().Perm())
+		_, err = io.Copy(hash, file)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, arg := range buildArgs {
+		fmt.Fprintf(hash, "ARG %s=%s\x00", arg.Name, arg.Value)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+//readDockerignore return the matcher of the .dockerignore of a context, nil without one
+func readDockerignore(contextDir string) (*patternmatcher.PatternMatcher, error) {
+	file, err := os.Open(filepath.Join(contextDir, ".dockerignore"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	patterns, err := ignorefile.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	return patternmatcher.New(patterns)
+}
+
+//isDockerignored match a context file against the .dockerignore patterns, like docker does
+//The Dockerfile and the .dockerignore are always sent
+func isDockerignored(ignored *patternmatcher.PatternMatcher, name string) (bool, error) {
+	if ignored == nil || name == "Dockerfile" || name == ".dockerignore" {
+		return false, nil
+	}
+	return ignored.MatchesOrParentMatches(name)
+}
@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPrunedBuilderTags(t *testing.T) {
+	history := []BuilderImage{
+		{Tag: "20170105-120000", ID: "e", Latest: true},
+		{Tag: "20170104-120000", ID: "d"},
+		{Tag: "20170103-120000", ID: "c", Pinned: true},
+		{Tag: "20170102-120000", ID: "b", Fallback: true},
+		{Tag: "20170101-120000", ID: "a"},
+	}
+	tests := []struct {
+		keep   int
+		pruned []string
+	}{
+		{5, nil},
+		{3, []string{"20170101-120000"}},
+		{1, []string{"20170104-120000", "20170101-120000"}},
+	}
+	for _, test := range tests {
+		if pruned := prunedBuilderTags(history, test.keep); !reflect.DeepEqual(pruned, test.pruned) {
+			t.Errorf("keep %d: got %v, expected %v", test.keep, pruned, test.pruned)
+		}
+	}
+}
+
+func TestContextHashDockerignore(t *testing.T) {
+	contextDir, err := ioutil.TempDir("", "gogobuild-context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(contextDir)
+	write := func(name string, content string) {
+		os.MkdirAll(filepath.Dir(filepath.Join(contextDir, name)), 0777)
+		if err := ioutil.WriteFile(filepath.Join(contextDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".dockerignore", "# build outputs\nbuild\n*.log\n!keep.log\n**/*.tmp\nDockerfile\n")
+	write("Dockerfile", "FROM debian")
+	write("setup.sh", "apt-get install")
+	write("build/out.o", "1")
+	write("keep.log", "1")
+	write("scripts/cache.tmp", "1")
+	initial, err := contextHash(contextDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		changed bool
+	}{
+		{"build/out.o", false},
+		{"build/sub/other.o", false},
+		{"debug.log", false},
+		{"scripts/cache.tmp", false},
+		{"keep.log", true},
+		{"setup.sh", true},
+		{"scripts/run.sh", true},
+		{"Dockerfile", true},
+	}
+	for _, test := range tests {
+		previous, _ := contextHash(contextDir, nil)
+		write(test.name, "changed "+test.name)
+		hash, err := contextHash(contextDir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed := hash != previous; changed != test.changed {
+			t.Errorf("%s: hash changed %v, expected %v", test.name, changed, test.changed)
+		}
+	}
+	if hash, _ := contextHash(contextDir, nil); hash == initial {
+		t.Error("expected the hash to change")
+	}
+}
+
+func TestBuilderImagesHosts(t *testing.T) {
+	repository := builderRepository("project", "win32")
+	builder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if versionPrefix.ReplaceAllString(r.URL.Path, "") != "/images/json" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[{"Id": "sha256:1234", "RepoTags": ["` + repository + `:20240102-150405", "` + repository + `:latest"], "Created": 1704207845}]`))
+	}))
+	defer builder.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	pool := &DockerHostPool{hosts: []*DockerHost{newTestHost(t, "down", down, 1), newTestHost(t, "builder", builder, 1)}}
+	images, err := pool.builderImages(repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || images[0].Host != "builder" || images[0].Tag != "20240102-150405" || !images[0].Latest {
+		t.Errorf("got images %+v", images)
+	}
+	if pool.hosts[0].Status().Healthy {
+		t.Error("expected the host down to be unhealthy")
+	}
+
+	//Not a single host answered, that is not an empty history
+	pool = &DockerHostPool{hosts: []*DockerHost{newTestHost(t, "down", down, 1)}}
+	if images, err := pool.builderImages(repository); err == nil {
+		t.Errorf("got images %+v, expected an error", images)
+	}
+}
@@ -0,0 +1,160 @@
+package controllers
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/revel/revel"
+)
+
+//containerOutput is the directory of a build container the output is written to
+const containerOutput = "/output"
+
+//mounts return the binds of a container on a host sharing the server files
+//The project is only mounted in build containers
+func (d *DockerWorker) mounts(withProject bool) []string {
+	if !d.host.Shared {
+		return nil
+	}
+	binds := []string{d.outputDir + ":" + containerOutput}
+	if withProject {
+		projectName := d.build.ProjectToBuild.Name
+		binds = append(binds, fmt.Sprintf("%s/public/projects/%s:/%s", revel.BasePath, projectName, projectName))
+	}
+	return binds
+}
+
+//upload copy the project (withProject) and an empty output directory in a created container
+//Nothing is done on a host sharing the server files, they are mounted
+func (d *DockerWorker) upload(containerID string, withProject bool) error {
+	if d.host.Shared {
+		return nil
+	}
+	reader, writer := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(writer)
+		err := tw.WriteHeader(&tar.Header{Name: strings.TrimPrefix(containerOutput, "/") + "/", Typeflag: tar.TypeDir, Mode: 0777})
+		if err == nil && withProject {
+			projectName := d.build.ProjectToBuild.Name
+			err = tarDirectory(tw, revel.BasePath+"/public/projects/"+projectName, projectName)
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	err := d.docker.UploadToContainer(containerID, docker.UploadToContainerOptions{InputStream: reader, Path: "/"})
+	reader.Close()
+	return err
+}
+
+//download copy the output directory of a stopped container to the build output directory
+//Nothing is done on a host sharing the server files, the output is already there
+func (d *DockerWorker) download(containerID string) error {
+	if d.host.Shared {
+		return nil
+	}
+	reader, writer := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- untarDirectory(reader, d.outputDir, path.Base(containerOutput))
+		reader.Close()
+	}()
+	err := d.docker.DownloadFromContainer(containerID, docker.DownloadFromContainerOptions{OutputStream: writer, Path: containerOutput})
+	writer.CloseWithError(err)
+	if errUntar := <-done; err == nil {
+		err = errUntar
+	}
+	return err
+}
+
+//tarDirectory write the content of dir under prefix in the archive
+func tarDirectory(tw *tar.Writer, dir string, prefix string) error {
+	return filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(prefix, filepath.ToSlash(name))
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+//untarDirectory extract the entries of the archive under prefix/ to dir
+//Only directories and regular files are extracted, an entry out of dir is an error
+func untarDirectory(r io.Reader, dir string, prefix string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		if name == prefix {
+			continue
+		}
+		if !strings.HasPrefix(name, prefix+"/") {
+			return fmt.Errorf("Unexpected entry %s", hdr.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(name, prefix+"/")))
+		if !strings.HasPrefix(target, filepath.Clean(dir)+string(filepath.Separator)) {
+			return fmt.Errorf("Entry %s is out of the output directory", hdr.Name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0777); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm()|0600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
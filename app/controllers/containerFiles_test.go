@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTarUntarDirectory(t *testing.T) {
+	source, err := ioutil.TempDir("", "gogobuild-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(source)
+	files := map[string]string{
+		"ring_amd64.exe":      "binary",
+		"doc/readme.txt":      "hello world",
+		"doc/x.txt":           "x",
+		"packages/deb/a.deb":  "deb",
+		"packages/deb/b.deb":  "deb2",
+		"packages/rpm/c.rpm":  "rpm",
+		"packages/notes.text": "notes",
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(source, name)), 0777)
+		if err := ioutil.WriteFile(filepath.Join(source, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	if err := tarDirectory(tw, source, "output"); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+
+	destination, err := ioutil.TempDir("", "gogobuild-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destination)
+	if err := untarDirectory(&archive, destination, "output"); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		data, err := ioutil.ReadFile(filepath.Join(destination, name))
+		if err != nil || string(data) != content {
+			t.Errorf("%s is %q, %v, expected %q", name, data, err, content)
+		}
+	}
+}
+
+func TestUntarDirectoryOutside(t *testing.T) {
+	destination, err := ioutil.TempDir("", "gogobuild-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destination)
+	for _, name := range []string{"output/../../evil.txt", "other/file.txt"} {
+		var archive bytes.Buffer
+		tw := tar.NewWriter(&archive)
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: 4})
+		tw.Write([]byte("evil"))
+		tw.Close()
+		if err := untarDirectory(&archive, destination, "output"); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s: got %v, expected an error", name, err)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/revel/revel"
+)
+
+//deployLog is written in the output directory by each deployment
+const deployLog = "deploy.txt"
+
+//s3Timeout bound each upload to an S3 endpoint
+const s3Timeout = 10 * time.Minute
+
+//DeployTarget of a target sys in .packer.json (DeployTargets)
+//Type is script (Script of scripts/), local (copy to Path), rsync (Host is user@host, Path the
+//remote directory, Port and Key the SSH port and identity file) or s3 (Endpoint, Bucket, Region,
+//Path is the key prefix, credentials are s3.<bucket>.access_key/secret_key in app.conf).
+//$GOGOBUILD_* variables of the build are expanded in Path.
+type DeployTarget struct {
+	Type     string
+	Script   string
+	Path     string
+	Host     string
+	Port     int
+	Key      string
+	Endpoint string
+	Bucket   string
+	Region   string
+}
+
+//Deployer interface
+//A Deployer publish the files of a build (relative to outputDir), its output goes to log
+type Deployer interface {
+	Deploy(build *Build, outputDir string, files []string, log io.Writer) error
+}
+
+//newDeployer return the Deployer of a target
+func newDeployer(target DeployTarget) (Deployer, error) {
+	switch target.Type {
+	case "script":
+		if len(target.Script) == 0 {
+			return nil, errors.New("No Script for the script deploy target")
+		}
+		return &ScriptDeployer{
+			Script:    fmt.Sprintf("%s/scripts/%s", revel.BasePath, target.Script),
+			TmpFolder: revel.Config.StringDefault("local_tmp_folder", ""),
+		}, nil
+	case "local":
+		if len(target.Path) == 0 {
+			return nil, errors.New("No Path for the local deploy target")
+		}
+		return &LocalDeployer{Path: target.Path}, nil
+	case "rsync":
+		if len(target.Host) == 0 || len(target.Path) == 0 {
+			return nil, errors.New("No Host or Path for the rsync deploy target")
+		}
+		return &RsyncDeployer{Host: target.Host, Path: target.Path, Port: target.Port, Key: target.Key}, nil
+	case "s3":
+		if len(target.Endpoint) == 0 || len(target.Bucket) == 0 {
+			return nil, errors.New("No Endpoint or Bucket for the s3 deploy target")
+		}
+		region := target.Region
+		if len(region) == 0 {
+			region = "us-east-1"
+		}
+		return &S3Deployer{
+			Endpoint:  target.Endpoint,
+			Bucket:    target.Bucket,
+			Region:    region,
+			Prefix:    target.Path,
+			AccessKey: revel.Config.StringDefault("s3."+target.Bucket+".access_key", revel.Config.StringDefault("s3.access_key", "")),
+			SecretKey: revel.Config.StringDefault("s3."+target.Bucket+".secret_key", revel.Config.StringDefault("s3.secret_key", "")),
+		}, nil
+	}
+	return nil, fmt.Errorf("Not a valid deploy type %s", target.Type)
+}
+
+//deployFiles return the files of a build to deploy: its artifacts and manifest,
+//or every file of the output directory for the builds made before the artifacts
+func (b *Build) deployFiles(outputDir string) ([]string, error) {
+	var files []string
+	if len(b.Artifacts) > 0 {
+		for _, artifact := range b.Artifacts {
+			files = append(files, artifact.Name)
+		}
+		if _, err := os.Stat(filepath.Join(outputDir, artifactManifest)); err == nil {
+			files = append(files, artifactManifest)
+		}
+		return files, nil
+	}
+	entries, err := ioutil.ReadDir(outputDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && !b.isReservedOutput(entry.Name()) {
+			files = append(files, entry.Name())
+		}
+	}
+	return files, nil
+}
+
+//expand the $GOGOBUILD_* (and project Env) variables of the build in s
+func (b *Build) expand(s string) string {
+	env := make(map[string]string)
+	for _, variable := range b.Env() {
+		parts := strings.SplitN(variable, "=", 2)
+		env[parts[0]] = parts[1]
+	}
+	return os.Expand(s, func(key string) string { return env[key] })
+}
+
+//ScriptDeployer run Script with the project name as argument (the former DeployScript)
+//The files are first copied to <TmpFolder><project>/packages/<sys> in their sub directory, the release
+//inserted in their name (ring_amd64.exe become ring-<release>_amd64.exe)
+type ScriptDeployer struct {
+	Script    string
+	TmpFolder string
+}
+
+//releaseName match the end of the names the release is inserted before
+var releaseName = regexp.MustCompile("(_amd64|_i386|\\.x86_64|\\.i686)?(\\.exe)$")
+
+//Deploy with the script
+func (s *ScriptDeployer) Deploy(build *Build, outputDir string, files []string, log io.Writer) error {
+	tmpFolder := fmt.Sprintf("%s%s/packages/%s", s.TmpFolder, build.ProjectToBuild.Name, strings.Replace(build.TargetSys, "_i386", "", -1))
+	if err := os.RemoveAll(tmpFolder); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmpFolder, 0777); err != nil {
+		return err
+	}
+	for _, file := range files {
+		target := filepath.Join(tmpFolder, releaseFileName(file, build.ReleaseString()))
+		if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+			return err
+		}
+		if err := copyFile(filepath.Join(outputDir, file), target); err != nil {
+			return err
+		}
+	}
+
+	cmd := exec.Command("/bin/bash", s.Script, build.ProjectToBuild.Name)
+	cmd.Env = append(os.Environ(), build.Env()...)
+	cmd.Env = append(cmd.Env, "GOGOBUILD_DEPLOY_DIR="+tmpFolder)
+	cmd.Stdout = log
+	cmd.Stderr = log
+	return cmd.Run()
+}
+
+//releaseFileName insert the release in the name of a file, its directory is kept
+func releaseFileName(file string, release string) string {
+	dir, name := filepath.Split(file)
+	return filepath.Join(dir, releaseName.ReplaceAllString(name, "-"+release+"$1$2"))
+}
+
+//LocalDeployer copy the files to a directory of the server (e.g a mounted share)
+type LocalDeployer struct {
+	Path string
+}
+
+//Deploy to the directory
+func (l *LocalDeployer) Deploy(build *Build, outputDir string, files []string, log io.Writer) error {
+	destination := build.expand(l.Path)
+	for _, file := range files {
+		target := filepath.Join(destination, file)
+		if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+			return err
+		}
+		if err := copyFile(filepath.Join(outputDir, file), target); err != nil {
+			return err
+		}
+		fmt.Fprintf(log, "%s -> %s\n", file, target)
+	}
+	return nil
+}
+
+//RsyncDeployer send the files over SSH with rsync
+//The host key must already be known, ssh run in batch mode
+type RsyncDeployer struct {
+	Host string
+	Path string
+	Port int
+	Key  string
+}
+
+//Deploy with rsync
+func (r *RsyncDeployer) Deploy(build *Build, outputDir string, files []string, log io.Writer) error {
+	cmd := exec.Command("rsync", r.args(build.expand(r.Path), files)...)
+	cmd.Dir = outputDir
+	cmd.Stdout = log
+	cmd.Stderr = log
+	return cmd.Run()
+}
+
+//args return the rsync arguments sending files to the destination directory
+//--relative keep the sub directories of the artifacts, the remote directory is created first.
+//The remote shell runs --rsync-path and rsync split -e like a shell, their words are quoted.
+//--protect-args send the paths without the remote shell.
+func (r *RsyncDeployer) args(destination string, files []string) []string {
+	ssh := []string{"ssh", "-o", "BatchMode=yes"}
+	if r.Port > 0 {
+		ssh = append(ssh, "-p", strconv.Itoa(r.Port))
+	}
+	if len(r.Key) > 0 {
+		ssh = append(ssh, "-i", shellQuote(r.Key))
+	}
+	args := []string{"-rtv", "--relative", "--protect-args", "-e", strings.Join(ssh, " "),
+		"--rsync-path", "mkdir -p -- " + shellQuote(destination) + " && rsync", "--"}
+	args = append(args, files...)
+	return append(args, r.Host+":"+strings.TrimSuffix(destination, "/")+"/")
+}
+
+//shellQuote quote s as a single word for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+//S3Deployer upload the files to a bucket of an S3 compatible storage (AWS, MinIO, ...)
+//Requests are signed with AWS Signature Version 4, buckets are addressed by path
+type S3Deployer struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+
+	client *http.Client
+}
+
+//Deploy to the bucket
+func (s *S3Deployer) Deploy(build *Build, outputDir string, files []string, log io.Writer) error {
+	if len(s.AccessKey) == 0 || len(s.SecretKey) == 0 {
+		return fmt.Errorf("No S3 credentials for %s in app.conf", s.Bucket)
+	}
+	prefix := strings.Trim(build.expand(s.Prefix), "/")
+	for _, file := range files {
+		key := path.Join(prefix, filepath.ToSlash(file))
+		if err := s.put(key, filepath.Join(outputDir, file)); err != nil {
+			return fmt.Errorf("Upload of %s: %s", file, err)
+		}
+		fmt.Fprintf(log, "%s -> s3://%s/%s\n", file, s.Bucket, key)
+	}
+	return nil
+}
+
+//put upload a file as key
+func (s *S3Deployer) put(key string, filename string) error {
+	payloadHash, size, err := fileSHA256(filename)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	endpoint, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/"))
+	if err != nil {
+		return err
+	}
+	endpoint.Path += "/" + s.Bucket + "/" + key
+	endpoint.RawPath = awsEscapePath(endpoint.Path)
+	req, err := http.NewRequest("PUT", endpoint.String(), file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	signV4(req, payloadHash, s.AccessKey, s.SecretKey, s.Region, "s3", time.Now().UTC())
+
+	client := s.client
+	if client == nil {
+		client = &http.Client{Timeout: s3Timeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+//signV4 sign a request with AWS Signature Version 4
+//The host, x-amz-content-sha256 and x-amz-date headers are signed
+func signV4(req *http.Request, payloadHash string, accessKey string, secretKey string, region string, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+	signature := hex.EncodeToString(hmacSHA256(signingKey(secretKey, date, region, service), stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+//signingKey derive the Signature Version 4 key of a day, region and service
+func signingKey(secretKey string, date string, region string, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+//awsEscapePath escape every character of a path but the unreserved ones and /
+func awsEscapePath(p string) string {
+	var escaped bytes.Buffer
+	for _, c := range []byte(p) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+	return escaped.String()
+}
+
+//fileSHA256 return the hex SHA-256 and size of a file
+func fileSHA256(filename string) (string, int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+//copyFile copy src to dst, dst is overwritten
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"bytes"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//maxDeploymentOutput is the size of the deployment output kept in the record, the end is kept
+const maxDeploymentOutput = 64 * 1024
+
+//Deployment is the record of one deploy of a build
+//By is who asked for it ("auto" for the Deploy builds), State is Building while it runs,
+//then Success or Fail. ExitCode is the one of the script or rsync, -1 if it did not run.
+type Deployment struct {
+	ID        bson.ObjectId `bson:"_id"`
+	BuildID   bson.ObjectId
+	Project   string
+	TargetSys string
+	Ref       string
+	Release   string
+	Target    DeployTarget
+	By        string
+	StartDate time.Time
+	EndDate   time.Time
+	State     State
+	ExitCode  int
+	Error     string
+	Output    string
+}
+
+//newDeployment of build
+func newDeployment(build *Build, by string) *Deployment {
+	return &Deployment{
+		ID:        bson.NewObjectId(),
+		BuildID:   build.ID,
+		Project:   build.ProjectToBuild.Name,
+		TargetSys: build.TargetSys,
+		Ref:       build.Commit,
+		Release:   build.ReleaseString(),
+		By:        by,
+		StartDate: time.Now(),
+		State:     Building,
+	}
+}
+
+//Duration of the deployment, until now if it is still running
+func (d *Deployment) Duration() time.Duration {
+	if d.State.IsRunning() {
+		return time.Since(d.StartDate)
+	}
+	return d.EndDate.Sub(d.StartDate)
+}
+
+//finish record the end of the deployment and its output
+func (d *Deployment) finish(err error, output *bytes.Buffer) {
+	d.EndDate = time.Now()
+	d.State = Success
+	if err != nil {
+		d.State = Fail
+		d.Error = err.Error()
+		d.ExitCode = exitCode(err)
+	}
+	data := output.Bytes()
+	if len(data) > maxDeploymentOutput {
+		data = data[len(data)-maxDeploymentOutput:]
+	}
+	d.Output = string(data)
+}
+
+//exitCode of a failed command, -1 if the error is not an exit status
+func exitCode(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			return status.ExitStatus()
+		}
+	}
+	return -1
+}
+
+//failUnfinishedDeployments fail the deployments still running in the store
+//A deployment can't be resumed, the server stopped in the middle of it
+func failUnfinishedDeployments(store BuildStore) error {
+	deployments, err := store.GetUnfinishedDeployments()
+	if err != nil {
+		return err
+	}
+	for i := range deployments {
+		deployments[i].State = Fail
+		deployments[i].EndDate = time.Now()
+		deployments[i].ExitCode = -1
+		deployments[i].Error = "Interrupted by a restart of the server"
+		if err := store.SaveDeployment(&deployments[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//liveDeployments return by target sys the last successful deployment, the one currently live
+//deployments must be sorted from the most recent
+func liveDeployments(deployments []Deployment) map[string]Deployment {
+	live := make(map[string]Deployment)
+	for _, deployment := range deployments {
+		if _, found := live[deployment.TargetSys]; !found && deployment.State == Success {
+			live[deployment.TargetSys] = deployment
+		}
+	}
+	return live
+}
@@ -0,0 +1,24 @@
+package controllers
+
+import (
+	"github.com/revel/revel"
+)
+
+//DeploymentsController Controller
+type DeploymentsController struct {
+	*revel.Controller
+}
+
+//Index page, deployment history of a project and the deployment live for each target sys
+func (c DeploymentsController) Index() revel.Result {
+	projectName := c.Params.Get("project")
+	deployments, err := BMInstance().GetDeploymentsByProject(projectName)
+	if err != nil {
+		c.Flash.Error(err.Error())
+	}
+	live := liveDeployments(deployments)
+	if c.Params.Get("format") == "json" {
+		return c.RenderJson(map[string]interface{}{"Live": live, "Deployments": deployments})
+	}
+	return c.Render(projectName, deployments, live)
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveDeployments(t *testing.T) {
+	now := time.Now()
+	deployments := []Deployment{
+		{TargetSys: "win32", Release: "4", State: Building, StartDate: now},
+		{TargetSys: "win32", Release: "3", State: Fail, StartDate: now.Add(-time.Hour)},
+		{TargetSys: "linux", Release: "2", State: Success, StartDate: now.Add(-2 * time.Hour)},
+		{TargetSys: "win32", Release: "2", State: Success, StartDate: now.Add(-3 * time.Hour)},
+		{TargetSys: "win32", Release: "1", State: Success, StartDate: now.Add(-4 * time.Hour)},
+		{TargetSys: "osx", Release: "1", State: Fail, StartDate: now.Add(-5 * time.Hour)},
+	}
+	live := liveDeployments(deployments)
+	if len(live) != 2 {
+		t.Errorf("got %d live deployments, expected 2", len(live))
+	}
+	for sys, release := range map[string]string{"win32": "2", "linux": "2"} {
+		if live[sys].Release != release {
+			t.Errorf("%s: got release %q live, expected %q", sys, live[sys].Release, release)
+		}
+	}
+	if _, found := live["osx"]; found {
+		t.Error("a failed deployment is not live")
+	}
+}
+
+func TestDeploymentFinish(t *testing.T) {
+	exitErr := exec.Command("/bin/sh", "-c", "exit 3").Run()
+	if _, ok := exitErr.(*exec.ExitError); !ok {
+		t.Fatalf("got %v, expected an exit error", exitErr)
+	}
+	long := strings.Repeat("a", maxDeploymentOutput) + "end"
+
+	tests := []struct {
+		name     string
+		err      error
+		output   string
+		state    State
+		exitCode int
+		kept     string
+	}{
+		{"success", nil, "deployed", Success, 0, "deployed"},
+		{"exit status", exitErr, "failed", Fail, 3, "failed"},
+		{"other error", errors.New("No Path"), "", Fail, -1, ""},
+		{"truncated", nil, long, Success, 0, long[len(long)-maxDeploymentOutput:]},
+	}
+	for _, test := range tests {
+		deployment := &Deployment{State: Building, StartDate: time.Now()}
+		deployment.finish(test.err, bytes.NewBufferString(test.output))
+		if deployment.State != test.state || deployment.ExitCode != test.exitCode {
+			t.Errorf("%s: got %s (%d), expected %s (%d)", test.name, deployment.State, deployment.ExitCode, test.state, test.exitCode)
+		}
+		if deployment.Output != test.kept {
+			t.Errorf("%s: got %d bytes of output, expected %d", test.name, len(deployment.Output), len(test.kept))
+		}
+		if test.err != nil && deployment.Error != test.err.Error() {
+			t.Errorf("%s: got error %q", test.name, deployment.Error)
+		}
+		if deployment.EndDate.IsZero() {
+			t.Errorf("%s: no end date", test.name)
+		}
+	}
+}
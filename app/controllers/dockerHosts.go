@@ -0,0 +1,347 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/revel/revel"
+)
+
+//ErrNoFreeHost is returned when every docker host able to run a build is busy or unhealthy
+//The build stays queued until one is free
+var ErrNoFreeHost = errors.New("No free docker host")
+
+//hostProbeTimeout bound the health checks and image lookups of the placement
+const hostProbeTimeout = 10 * time.Second
+
+//DockerHost is a docker daemon builds run on
+//Capacity is the number of builds it runs at the same time, Labels are matched
+//against the HostLabels of the target sys in .packer.json
+//Shared is set when the host sees the server files at the same paths (e.g the local daemon),
+//the project and output directories are then bind mounted instead of copied (see DockerWorker)
+type DockerHost struct {
+	Name     string
+	Endpoint string
+	Labels   []string
+	Capacity int
+	Shared   bool
+
+	client *docker.Client
+	probe  *docker.Client
+
+	mutex     sync.Mutex
+	running   int
+	healthy   bool
+	lastError string
+}
+
+//DockerHostStatus is the state of a docker host shown on the queue page
+type DockerHostStatus struct {
+	Name      string
+	Endpoint  string
+	Labels    []string
+	Capacity  int
+	Running   int
+	Healthy   bool
+	LastError string
+}
+
+//newDockerHost connect to a docker endpoint (unix:// or tcp://)
+//TLS is used when cert, key and ca are given
+func newDockerHost(name string, endpoint string, capacity int, labels []string, cert string, key string, ca string) (*DockerHost, error) {
+	newClient := func() (*docker.Client, error) {
+		if len(cert) > 0 {
+			return docker.NewTLSClient(endpoint, cert, key, ca)
+		}
+		return docker.NewClient(endpoint)
+	}
+	client, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	//A second client with a timeout, the build one wait as long as the containers run
+	probe, err := newClient()
+	if err != nil {
+		return nil, err
+	}
+	if probe.HTTPClient != nil {
+		probe.HTTPClient.Timeout = hostProbeTimeout
+	}
+	return &DockerHost{
+		Name:     name,
+		Endpoint: endpoint,
+		Labels:   labels,
+		Capacity: capacity,
+		client:   client,
+		probe:    probe,
+		Shared:   strings.HasPrefix(endpoint, "unix://"),
+		healthy:  true,
+	}, nil
+}
+
+//hasLabels return true if the host has all the labels
+func (h *DockerHost) hasLabels(labels []string) bool {
+	for _, label := range labels {
+		found := false
+		for _, hostLabel := range h.Labels {
+			if hostLabel == label {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+//reserve a slot, only if one is free unless force is set (reattached builds)
+func (h *DockerHost) reserve(force bool) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if !force && (!h.healthy || h.running >= h.Capacity) {
+		return false
+	}
+	h.running++
+	return true
+}
+
+//release the slot of a finished build
+func (h *DockerHost) release() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.running > 0 {
+		h.running--
+	}
+}
+
+//isAvailable return true if the host is healthy and has a free slot
+func (h *DockerHost) isAvailable() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.healthy && h.running < h.Capacity
+}
+
+//load of the host, running builds by slot
+func (h *DockerHost) load() float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return float64(h.running) / float64(h.Capacity)
+}
+
+//setHealth record the result of the last request to the host
+//and return true if the host just came back
+func (h *DockerHost) setHealth(err error) bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	wasHealthy := h.healthy
+	h.healthy = err == nil
+	if err != nil {
+		h.lastError = err.Error()
+		if wasHealthy {
+			log.Printf("Docker host %s (%s) is unhealthy: %s", h.Name, h.Endpoint, err)
+		}
+		return false
+	}
+	h.lastError = ""
+	return !wasHealthy
+}
+
+//hasImage return true if the image is on the host
+func (h *DockerHost) hasImage(image string) (bool, error) {
+	_, err := h.probe.InspectImage(image)
+	if err == docker.ErrNoSuchImage {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+//Status of the host
+func (h *DockerHost) Status() DockerHostStatus {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return DockerHostStatus{
+		Name:      h.Name,
+		Endpoint:  h.Endpoint,
+		Labels:    h.Labels,
+		Capacity:  h.Capacity,
+		Running:   h.running,
+		Healthy:   h.healthy,
+		LastError: h.lastError,
+	}
+}
+
+//DockerHostPool singleton, the docker hosts of app.conf
+type DockerHostPool struct {
+	hosts []*DockerHost
+}
+
+//hostPoolInstance of DockerHostPool
+var hostPoolInstance *DockerHostPool
+
+//HPInstance return the instance
+func HPInstance() *DockerHostPool {
+	if hostPoolInstance == nil {
+		hostPoolInstance = new(DockerHostPool)
+		if err := hostPoolInstance.init(); err != nil {
+			revel.ERROR.Println(err)
+		}
+	}
+	return hostPoolInstance
+}
+
+//init read the hosts of app.conf
+//docker.hosts list the host names, each of them configured by docker.<name>.endpoint,
+//.capacity, .labels, .shared and .tls.cert, .tls.key, .tls.ca
+//Without docker.hosts the local daemon is used with build.slots as capacity
+func (p *DockerHostPool) init() error {
+	names := revel.Config.StringDefault("docker.hosts", "")
+	if len(strings.TrimSpace(names)) == 0 {
+		host, err := newDockerHost("local", "unix:///var/run/docker.sock", revel.Config.IntDefault("build.slots", 4), nil, "", "", "")
+		if err != nil {
+			return err
+		}
+		p.hosts = append(p.hosts, host)
+		return nil
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		prefix := "docker." + name + "."
+		var labels []string
+		for _, label := range strings.Split(revel.Config.StringDefault(prefix+"labels", ""), ",") {
+			if label = strings.TrimSpace(label); len(label) > 0 {
+				labels = append(labels, label)
+			}
+		}
+		host, err := newDockerHost(name,
+			revel.Config.StringDefault(prefix+"endpoint", "unix:///var/run/docker.sock"),
+			revel.Config.IntDefault(prefix+"capacity", 1),
+			labels,
+			revel.Config.StringDefault(prefix+"tls.cert", ""),
+			revel.Config.StringDefault(prefix+"tls.key", ""),
+			revel.Config.StringDefault(prefix+"tls.ca", ""))
+		if err != nil {
+			return fmt.Errorf("Docker host %s: %s", name, err)
+		}
+		host.Shared = revel.Config.BoolDefault(prefix+"shared", host.Shared)
+		p.hosts = append(p.hosts, host)
+	}
+	return nil
+}
+
+//Place choose the host of a build and reserve one of its slots
+//Among the hosts with the labels of the target sys and a free slot, the least loaded one
+//already having the builder image is preferred. ErrNoFreeHost is returned if they are all busy.
+func (p *DockerHostPool) Place(build *Build) (*DockerHost, error) {
+	labels := build.ProjectToBuild.Configuration.HostLabels[build.TargetSys]
+	image := builderRepository(build.ProjectToBuild.Name, build.TargetSys) + ":fallback"
+
+	var candidates []*DockerHost
+	matching := false
+	for _, host := range p.hosts {
+		if !host.hasLabels(labels) {
+			continue
+		}
+		matching = true
+		if host.isAvailable() {
+			candidates = append(candidates, host)
+		}
+	}
+	if !matching {
+		return nil, fmt.Errorf("No docker host with the labels %s", strings.Join(labels, ","))
+	}
+
+	//A host that does not answer is marked unhealthy and skipped
+	var withImage, withoutImage []*DockerHost
+	for _, host := range candidates {
+		found, err := host.hasImage(image)
+		if err != nil {
+			host.setHealth(err)
+			continue
+		}
+		if found {
+			withImage = append(withImage, host)
+		} else {
+			withoutImage = append(withoutImage, host)
+		}
+	}
+
+	//Another placement may take the last slot of a host first, the next one is tried
+	for _, hosts := range [][]*DockerHost{withImage, withoutImage} {
+		sort.SliceStable(hosts, func(i, j int) bool { return hosts[i].load() < hosts[j].load() })
+		for _, host := range hosts {
+			if host.reserve(false) {
+				return host, nil
+			}
+		}
+	}
+	return nil, ErrNoFreeHost
+}
+
+//FindContainer look on every host for the containers of a build left by a previous
+//instance of the server and reserve a slot on the host having them
+func (p *DockerHostPool) FindContainer(build *Build) (*DockerHost, []docker.APIContainers, error) {
+	var lastErr error
+	for _, host := range p.hosts {
+		containers, err := host.client.ListContainers(docker.ListContainersOptions{
+			All:     true,
+			Filters: map[string][]string{"label": {buildLabel + "=" + build.ID.Hex()}},
+		})
+		if err != nil {
+			host.setHealth(err)
+			lastErr = err
+			continue
+		}
+		if len(containers) > 0 {
+			host.reserve(true)
+			return host, containers, nil
+		}
+	}
+	if lastErr != nil {
+		return nil, nil, lastErr
+	}
+	return nil, nil, errors.New("No container left for this build")
+}
+
+//CheckHealth ping every host, queued builds are dispatched when one come back
+func (p *DockerHostPool) CheckHealth() {
+	recovered := false
+	for _, host := range p.hosts {
+		if host.setHealth(host.probe.Ping()) {
+			log.Printf("Docker host %s (%s) is back", host.Name, host.Endpoint)
+			recovered = true
+		}
+	}
+	if recovered {
+		WMInstance().Dispatch()
+	}
+}
+
+//GetHost return a host by its name
+func (p *DockerHostPool) GetHost(name string) (*DockerHost, error) {
+	for _, host := range p.hosts {
+		if host.Name == name {
+			return host, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown docker host %s", name)
+}
+
+//Hosts return the status of the hosts
+func (p *DockerHostPool) Hosts() []DockerHostStatus {
+	var hosts []DockerHostStatus
+	for _, host := range p.hosts {
+		hosts = append(hosts, host.Status())
+	}
+	return hosts
+}
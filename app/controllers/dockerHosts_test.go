@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+//versionPrefix is the optional API version of the docker requests
+var versionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+//newDockerStandIn serve the parts of the Docker API used by the placement
+//The images are given by their name, e.g gogobuild/project_win32:fallback
+func newDockerStandIn(t *testing.T, images ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := versionPrefix.ReplaceAllString(r.URL.Path, "")
+		switch {
+		case path == "/_ping":
+			w.Write([]byte("OK"))
+		case strings.HasPrefix(path, "/images/") && strings.HasSuffix(path, "/json"):
+			name := strings.TrimSuffix(strings.TrimPrefix(path, "/images/"), "/json")
+			for _, image := range images {
+				if image == name {
+					w.Header().Set("Content-Type", "application/json")
+					w.Write([]byte(`{"Id": "sha256:1234", "Config": {"Labels": {}}}`))
+					return
+				}
+			}
+			http.Error(w, `{"message": "No such image"}`, http.StatusNotFound)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func newTestHost(t *testing.T, name string, server *httptest.Server, capacity int, labels ...string) *DockerHost {
+	host, err := newDockerHost(name, server.URL, capacity, labels, "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host
+}
+
+func newTestBuild(sys string, labels ...string) *Build {
+	build := &Build{TargetSys: sys}
+	build.ProjectToBuild.Name = "project"
+	build.ProjectToBuild.Configuration.HostLabels = map[string][]string{sys: labels}
+	return build
+}
+
+func TestDockerHostPlacePreferImage(t *testing.T) {
+	empty := newDockerStandIn(t)
+	defer empty.Close()
+	withImage := newDockerStandIn(t, "gogobuild/project_win32:fallback")
+	defer withImage.Close()
+
+	pool := &DockerHostPool{hosts: []*DockerHost{
+		newTestHost(t, "empty", empty, 2),
+		newTestHost(t, "builder", withImage, 2),
+	}}
+	host, err := pool.Place(newTestBuild("win32"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host.Name != "builder" {
+		t.Errorf("placed on %s, expected builder", host.Name)
+	}
+	if status := host.Status(); status.Running != 1 {
+		t.Errorf("%d running builds, expected 1", status.Running)
+	}
+}
+
+func TestDockerHostPlaceCapacity(t *testing.T) {
+	server := newDockerStandIn(t, "gogobuild/project_win32:fallback")
+	defer server.Close()
+
+	host := newTestHost(t, "builder", server, 1)
+	pool := &DockerHostPool{hosts: []*DockerHost{host}}
+	if _, err := pool.Place(newTestBuild("win32")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Place(newTestBuild("win32")); err != ErrNoFreeHost {
+		t.Errorf("got %v, expected ErrNoFreeHost", err)
+	}
+	host.release()
+	if _, err := pool.Place(newTestBuild("win32")); err != nil {
+		t.Errorf("got %v once the slot is released", err)
+	}
+}
+
+func TestDockerHostPlaceLabels(t *testing.T) {
+	server := newDockerStandIn(t)
+	defer server.Close()
+
+	pool := &DockerHostPool{hosts: []*DockerHost{
+		newTestHost(t, "linux", server, 1, "linux"),
+		newTestHost(t, "mingw", server, 1, "linux", "mingw"),
+	}}
+	host, err := pool.Place(newTestBuild("win32", "mingw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host.Name != "mingw" {
+		t.Errorf("placed on %s, expected mingw", host.Name)
+	}
+	//No host at all can run it, that's not something to wait for
+	if _, err := pool.Place(newTestBuild("osx", "osx")); err == nil || err == ErrNoFreeHost {
+		t.Errorf("got %v, expected a labels error", err)
+	}
+}
+
+func TestDockerHostHealth(t *testing.T) {
+	down := newDockerStandIn(t)
+	up := newDockerStandIn(t)
+	defer up.Close()
+
+	pool := &DockerHostPool{hosts: []*DockerHost{
+		newTestHost(t, "down", down, 4),
+		newTestHost(t, "up", up, 1),
+	}}
+	down.Close()
+	pool.CheckHealth()
+
+	statuses := pool.Hosts()
+	if statuses[0].Healthy || len(statuses[0].LastError) == 0 {
+		t.Errorf("down is %+v, expected unhealthy", statuses[0])
+	}
+	if !statuses[1].Healthy {
+		t.Errorf("up is %+v, expected healthy", statuses[1])
+	}
+
+	host, err := pool.Place(newTestBuild("win32"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host.Name != "up" {
+		t.Errorf("placed on %s, expected up", host.Name)
+	}
+	if _, err := pool.Place(newTestBuild("win32")); err != ErrNoFreeHost {
+		t.Errorf("got %v, expected ErrNoFreeHost with down unhealthy", err)
+	}
+}
+
+func TestDockerHostPlaceLostReserve(t *testing.T) {
+	image := "gogobuild/project_win32:fallback"
+	standIn := newDockerStandIn(t, image)
+	defer standIn.Close()
+	var builder *DockerHost
+	//Another placement takes the last slot of builder while its image is looked up
+	racing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/images/") {
+			builder.reserve(false)
+		}
+		standIn.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer racing.Close()
+	empty := newDockerStandIn(t)
+	defer empty.Close()
+
+	builder = newTestHost(t, "builder", racing, 1)
+	pool := &DockerHostPool{hosts: []*DockerHost{builder, newTestHost(t, "other", empty, 1)}}
+	host, err := pool.Place(newTestBuild("win32"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host.Name != "other" {
+		t.Errorf("placed on %s, expected other", host.Name)
+	}
+	if status := builder.Status(); status.Running != 1 {
+		t.Errorf("%d running builds on builder, expected 1", status.Running)
+	}
+}
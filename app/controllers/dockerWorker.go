@@ -1,23 +1,33 @@
 package controllers
 
 import (
-	"archive/tar"
-	"bytes"
+	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"log"
 	"os"
-	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/revel/revel"
 )
 
+//Labels set on the build containers so they can be found back after a restart
+const (
+	buildLabel = "gogobuild.build"
+	stepLabel  = "gogobuild.step"
+	imageLabel = "gogobuild.image"
+)
+
+//contextLabel is set on the builder images with the hash of their docker context
+const contextLabel = "gogobuild.context"
+
 //DockerWorker Controller implementing Worker interface
 type DockerWorker struct {
+	host             *DockerHost
 	docker           *docker.Client
 	build            Build
 	targetSys        string
@@ -25,18 +35,66 @@ type DockerWorker struct {
 	logFile          *os.File
 	outputDir        string
 	commitToFallback bool
+
+	mutex       sync.Mutex
+	containerID string
+	cancelImage context.CancelFunc
+	stopped     bool
+	stopState   State
+
+	resumeContainer *docker.APIContainers
+	logsSince       int64
+}
+
+func init() {
+	RegisterWorker("Docker", func(build *Build) Worker {
+		return &DockerWorker{build: *build, targetSys: build.TargetSys}
+	})
 }
 
 func (d *DockerWorker) init() error {
-	var err error
-	d.docker, err = docker.NewClient("unix:///var/run/docker.sock")
+	if d.host == nil {
+		return errors.New("No docker host for this build")
+	}
+	d.docker = d.host.client
+	d.imageName = builderRepository(d.build.ProjectToBuild.Name, d.targetSys) + ":%s"
+	return nil
+}
 
-	return err
+//Place the build on one of the docker hosts (see DockerHostPool.Place)
+func (d *DockerWorker) Place() error {
+	host, err := HPInstance().Place(&d.build)
+	if err != nil {
+		return err
+	}
+	d.host = host
+	return nil
 }
 
 //Run the DockerWorker
-func (d DockerWorker) Run() {
+func (d *DockerWorker) Run() {
 	var err error
+	if d.host != nil {
+		defer d.host.release()
+	}
+
+	if d.isStopped() {
+		d.build.State = d.stoppedState()
+		BMInstance().UpdateBuild(&d.build)
+		return
+	}
+	if timeout := d.build.ProjectToBuild.Configuration.GetBuildTimeout(d.targetSys); timeout > 0 {
+		//A resumed build only get what is left since it started
+		if d.resumeContainer != nil && !d.build.StartDate.IsZero() {
+			timeout -= time.Since(d.build.StartDate)
+		}
+		timer := time.AfterFunc(timeout, func() { d.stop(TimedOut) })
+		defer timer.Stop()
+	}
+	if d.resumeContainer != nil {
+		d.resume()
+		return
+	}
 
 	d.build.StartDate = time.Now()
 	BMInstance().UpdateBuild(&d.build)
@@ -48,21 +106,52 @@ func (d DockerWorker) Run() {
 	err = d.init()
 	if err != nil {
 		d.logFile.WriteString(err.Error())
+		d.build.State = Fail
+		BMInstance().UpdateBuild(&d.build)
+		d.logFile.Close()
 		return
 	}
-	d.imageName = fmt.Sprintf("gogobuild/%s_%s:", d.build.ProjectToBuild.Name, strings.ToLower(d.targetSys)) + "%s"
+	d.logFile.WriteString(fmt.Sprintf("Building on docker host %s\n", d.host.Name))
 
-	//Check if the fallback image exists else it's the first time we need to build it
-	_, err = d.docker.InspectImage(fmt.Sprintf(d.imageName, "fallback"))
-	if err == docker.ErrNoSuchImage {
-		d.logFile.WriteString("No image found, try to build it.")
-		d.buildImage()
+	//Build the fallback image the first time, when its docker context changed or when asked to
+	contextDir := d.contextDir()
+	hash, err := contextHash(contextDir, d.build.ProjectToBuild.Configuration.GetBuildArgs(d.targetSys))
+	if err != nil {
+		d.logFile.WriteString(err.Error())
+		d.build.State = Fail
+		BMInstance().UpdateBuild(&d.build)
+		d.logFile.Close()
 		return
 	}
+	rebuild := ""
+	image, err := d.docker.InspectImage(fmt.Sprintf(d.imageName, "fallback"))
+	if err == docker.ErrNoSuchImage {
+		rebuild = "No image found, try to build it."
+		err = nil
+	} else if err == nil && d.build.IsImageRebuild() {
+		rebuild = "Rebuilding the image."
+	} else if err == nil && !d.isPinned(image) && image.Config != nil && len(image.Config.Labels[contextLabel]) > 0 && image.Config.Labels[contextLabel] != hash {
+		//Images built before the label was introduced and pinned images are kept
+		rebuild = "Docker context changed, rebuilding the image."
+	}
 	if err != nil {
 		d.logFile.WriteString(err.Error())
 		d.build.State = Fail
 		BMInstance().UpdateBuild(&d.build)
+		d.logFile.Close()
+		return
+	}
+	if len(rebuild) > 0 {
+		d.logFile.WriteString(rebuild)
+		if err := d.buildImage(contextDir, hash); err != nil {
+			d.setFinalState(false, err)
+			d.logFile.Close()
+			return
+		}
+	}
+	if d.build.IsImageRebuild() {
+		d.setFinalState(false, nil)
+		d.logFile.Close()
 		return
 	}
 
@@ -70,47 +159,60 @@ func (d DockerWorker) Run() {
 	return
 }
 
-//buildImage build an image from
-func (d *DockerWorker) buildImage() error {
+//isPinned return true if the fallback image is the one picked on the images page or an update of it
+func (d *DockerWorker) isPinned(fallback *docker.Image) bool {
+	pinned, err := d.docker.InspectImage(fmt.Sprintf(d.imageName, pinnedTag))
+	return err == nil && pinned.ID == fallback.ID
+}
 
-	t := time.Now()
-	inputbuf, outputbuf := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
-	file, err := os.Open(revel.BasePath + "/public/projects/" + d.build.ProjectToBuild.Name + "/docker/" + d.build.TargetSys + "/Dockerfile")
-	if err != nil {
+//contextDir return the directory of the Dockerfile of the builder image
+func (d *DockerWorker) contextDir() string {
+	return revel.BasePath + "/public/projects/" + d.build.ProjectToBuild.Name + "/docker/" + d.build.TargetSys
+}
+
+//buildImage build the fallback image from docker/<sys>/ of the project
+//The whole directory is the build context (.dockerignore is respected), the output goes to the log
+//The context hash is kept as a label to rebuild the image when the context change
+func (d *DockerWorker) buildImage(contextDir string, hash string) error {
+	if _, err := os.Stat(contextDir + "/Dockerfile"); err != nil {
 		d.logFile.WriteString(err.Error())
-		d.build.State = Fail
-		BMInstance().UpdateBuild(&d.build)
 		return err
 	}
-	defer file.Close()
-	fileContent, err := ioutil.ReadAll(file)
-	if err != nil {
-		d.logFile.WriteString(err.Error())
-		d.build.State = Fail
-		BMInstance().UpdateBuild(&d.build)
+	d.build.State = Init
+	BMInstance().UpdateBuild(&d.build)
+	d.logFile.WriteString("\n\n ---IMAGE BUILD OUTPUT---- \n")
+
+	//stop() cancel the image build
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := d.setCancelImage(cancel); err != nil {
 		return err
 	}
-	tr := tar.NewWriter(inputbuf)
-	tr.WriteHeader(&tar.Header{Name: "Dockerfile", Size: int64(len(fileContent)), ModTime: t, AccessTime: t, ChangeTime: t})
-	tr.Write(fileContent)
-	tr.Close()
+	defer d.setCancelImage(nil)
 
 	opts := docker.BuildImageOptions{
+		Context:        ctx,
 		Name:           fmt.Sprintf(d.imageName, "fallback"),
 		RmTmpContainer: true,
-		InputStream:    inputbuf,
-		OutputStream:   outputbuf,
+		ContextDir:     contextDir,
+		BuildArgs:      d.build.ProjectToBuild.Configuration.GetBuildArgs(d.targetSys),
+		OutputStream:   d.logFile,
+		Labels:         map[string]string{contextLabel: hash},
 		NoCache:        true,
 	}
 	if err := d.docker.BuildImage(opts); err != nil {
 		d.logFile.WriteString(err.Error())
-		d.build.State = Fail
-		BMInstance().UpdateBuild(&d.build)
-	} else {
-		d.logFile.Write(outputbuf.Bytes())
-		d.startBuild()
+		return err
 	}
-
+	//New version of the image history, latest point to it too
+	repository := builderRepository(d.build.ProjectToBuild.Name, d.targetSys)
+	tag := newBuilderImageTag()
+	d.logFile.WriteString(fmt.Sprintf("\n\n---Builder image %s:%s---\n", repository, tag))
+	d.docker.TagImage(fmt.Sprintf(d.imageName, "fallback"), docker.TagImageOptions{Repo: repository, Tag: tag})
+	if err := d.docker.TagImage(fmt.Sprintf(d.imageName, "fallback"), docker.TagImageOptions{Repo: repository, Tag: "latest", Force: true}); err != nil {
+		return err
+	}
+	d.pruneImages(repository)
 	return nil
 }
 
@@ -132,27 +234,102 @@ func (d *DockerWorker) startBuild() error {
 	if useFallbackImage == false || d.commitToFallback == true {
 		//try to make an up to date image
 		err = d.tryUpdate()
-		if err != nil {
-			useFallbackImage = true
-			d.logFile.WriteString("\n\n---Update Image failed falling back---\n")
-		} else {
-			d.logFile.WriteString("\n\n---Using updated image---\n")
-		}
+		useFallbackImage = d.updateResult(err, useFallbackImage)
+	}
+	d.continueBuild(useFallbackImage, err)
+	return nil
+}
+
+//resume a build whose container survived a server restart
+func (d *DockerWorker) resume() {
+	var err error
+	container := d.resumeContainer
+
+	d.outputDir = fmt.Sprintf("%s/public/output/%s/%d/%s", revel.BasePath, d.build.ProjectToBuild.Name, d.build.Date.Unix(), d.build.TargetSys)
+	os.MkdirAll(d.outputDir, 0777)
+	//Only append what was produced while the server was down
+	if stat, err := os.Stat(d.outputDir + "/logs.txt"); err == nil {
+		d.logsSince = stat.ModTime().Unix()
+	}
+	d.logFile, err = os.OpenFile(d.outputDir+"/logs.txt", os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		log.Println(err)
+		d.build.State = Fail
+		BMInstance().UpdateBuild(&d.build)
+		return
+	}
+	defer d.logFile.Close()
+	d.logFile.WriteString("\n\n---Server restarted, resuming build---\n")
+
+	if d.build.Commit == "updateWorker" {
+		d.commitToFallback = true
+	}
+	if err := d.setContainer(container.ID); err != nil {
+		d.setFinalState(false, err)
+		return
+	}
+
+	switch container.Labels[stepLabel] {
+	case "update":
+		useFallbackImage := d.build.Commit != "master"
+		err = d.waitUpdate(container.ID, time.Unix(container.Created, 0).Round(time.Second))
+		d.setContainer("")
+		useFallbackImage = d.updateResult(err, useFallbackImage)
+		d.continueBuild(useFallbackImage, err)
+	default:
+		useFallbackImage := container.Labels[imageLabel] == "fallback"
+		err = d.waitProject(container.ID, container.Labels[imageLabel])
+		d.setContainer("")
+		useFallbackImage, err = d.retryWithFallback(useFallbackImage, err)
+		d.setFinalState(useFallbackImage, err)
+	}
+}
+
+//updateResult log the update result and return if the fallback image must be used
+func (d *DockerWorker) updateResult(err error, useFallbackImage bool) bool {
+	if err != nil && d.isStopped() {
+		d.logFile.WriteString(fmt.Sprintf("\n\n---Build %s---\n", d.stoppedState()))
+	} else if err != nil {
+		useFallbackImage = true
+		d.logFile.WriteString("\n\n---Update Image failed falling back---\n")
+	} else {
+		d.logFile.WriteString("\n\n---Using updated image---\n")
 	}
+	return useFallbackImage
+}
 
+//continueBuild build the project after the update and set the final state
+func (d *DockerWorker) continueBuild(useFallbackImage bool, err error) {
 	//Don't build the project if that's an update build
-	if d.commitToFallback == false {
+	if d.commitToFallback == false && d.isStopped() == false {
 		//build the project
 		err = d.buildProject(useFallbackImage)
-		if err != nil && useFallbackImage == false {
-			//Last chance to make it work
-			d.logFile.WriteString("\n\n---Build with updated image failed, falling back...---\n")
-			useFallbackImage = true
-			err = d.buildProject(useFallbackImage)
+		useFallbackImage, err = d.retryWithFallback(useFallbackImage, err)
+	}
+	d.setFinalState(useFallbackImage, err)
+}
+
+//retryWithFallback give a last chance to a build that failed with the updated image
+func (d *DockerWorker) retryWithFallback(useFallbackImage bool, err error) (bool, error) {
+	if err != nil && useFallbackImage == false && d.isStopped() == false {
+		d.logFile.WriteString("\n\n---Build with updated image failed, falling back...---\n")
+		useFallbackImage = true
+		err = d.buildProject(useFallbackImage)
+	}
+	return useFallbackImage, err
+}
+
+//setFinalState of the build
+func (d *DockerWorker) setFinalState(useFallbackImage bool, err error) {
+	//A build missing a required artifact is failed
+	if err == nil && d.isStopped() == false && d.build.IsBuilderUpdate() == false {
+		if err = d.build.collectArtifacts(d.outputDir); err != nil {
+			d.logFile.WriteString("\nARTIFACTS: " + err.Error() + "\n")
 		}
 	}
-	//Set the build final state
-	if err != nil {
+	if d.isStopped() {
+		d.build.State = d.stoppedState()
+	} else if err != nil {
 		d.build.State = Fail
 	} else {
 		if useFallbackImage && d.commitToFallback == false {
@@ -162,7 +339,6 @@ func (d *DockerWorker) startBuild() error {
 		}
 	}
 	BMInstance().UpdateBuild(&d.build)
-	return nil
 }
 
 func (d *DockerWorker) tryUpdate() error {
@@ -172,7 +348,7 @@ func (d *DockerWorker) tryUpdate() error {
 	var cmds []string
 	cmds = append(cmds, "bash")
 	cmds = append(cmds, "-c")
-	cmds = append(cmds, strings.Join(d.build.ProjectToBuild.Configuration.UpdateInstructions[d.targetSys], " && "), d.build.Commit)
+	cmds = append(cmds, strings.Join(d.build.UpdateInstructions(), " && "), d.build.Commit)
 	d.logFile.WriteString(strings.Join(cmds, "\n"))
 	d.logFile.WriteString("\n\n ---UPDATE OUTPUT---- \n")
 
@@ -182,9 +358,11 @@ func (d *DockerWorker) tryUpdate() error {
 		AttachStderr: false,
 		Tty:          false,
 		Cmd:          cmds,
+		Env:          d.build.Env(),
 		Image:        fmt.Sprintf(d.imageName, "fallback"),
+		Labels:       d.containerLabels("update", "fallback"),
 	}
-	hostConfig := &docker.HostConfig{Binds: []string{d.outputDir + ":/output"}}
+	hostConfig := &docker.HostConfig{Binds: d.mounts(false)}
 	containerConfig := docker.CreateContainerOptions{
 		Config:     config,
 		HostConfig: hostConfig,
@@ -195,14 +373,14 @@ func (d *DockerWorker) tryUpdate() error {
 		log.Println(err)
 		return err
 	}
-
-	logOptions := docker.LogsOptions{
-		Stdout:       true,
-		Stderr:       true,
-		Timestamps:   true,
-		Container:    container.ID,
-		OutputStream: d.logFile,
-		ErrorStream:  d.logFile,
+	if err := d.setContainer(container.ID); err != nil {
+		return err
+	}
+	defer d.setContainer("")
+	if err := d.upload(container.ID, false); err != nil {
+		d.logFile.WriteString("\n" + err.Error())
+		d.destroy(container.ID)
+		return err
 	}
 
 	err = d.docker.StartContainer(container.ID, hostConfig)
@@ -211,10 +389,21 @@ func (d *DockerWorker) tryUpdate() error {
 		log.Println(err)
 		return err
 	}
+	return d.waitUpdate(container.ID, start)
+}
+
+//waitUpdate wait for the update container and commit the updated image
+func (d *DockerWorker) waitUpdate(containerID string, start time.Time) error {
+	if timeout := d.build.ProjectToBuild.Configuration.GetUpdateTimeout(d.targetSys); timeout > 0 {
+		timer := time.AfterFunc(timeout-time.Since(start), func() { d.stop(TimedOut) })
+		defer timer.Stop()
+	}
+	logDone := d.followLogs(containerID, d.logFile)
+
 	//Wait for the container
-	retValue, err := d.docker.WaitContainer(container.ID)
+	retValue, err := d.docker.WaitContainer(containerID)
 
-	errLog := d.docker.Logs(logOptions)
+	errLog := <-logDone
 	if errLog != nil {
 		log.Println(errLog.Error())
 	}
@@ -224,37 +413,64 @@ func (d *DockerWorker) tryUpdate() error {
 	BMInstance().UpdateBuild(&d.build)
 
 	if retValue != 0 || err != nil {
-		d.destroy(container.ID)
+		d.destroy(containerID)
 		return errors.New("Update Failed")
 	}
-	err = d.docker.RemoveImage(fmt.Sprintf(d.imageName, "latest"))
-	if err != nil && err != docker.ErrNoSuchImage {
-		return err
-	}
 	suffix := "latest"
 	if d.commitToFallback == true {
 		suffix = "fallback"
 	}
-	_, err = d.docker.CommitContainer(docker.CommitContainerOptions{Container: container.ID, Repository: fmt.Sprintf("gogobuild/%s_%s", d.build.ProjectToBuild.Name, strings.ToLower(d.targetSys)), Tag: suffix})
-	d.destroy(container.ID)
-	return err
+	//Every update is kept with its own tag (see ListBuilderImages), latest or fallback then point to it
+	//The update of a pinned fallback image stays pinned
+	repository := builderRepository(d.build.ProjectToBuild.Name, d.targetSys)
+	tag := newBuilderImageTag()
+	pinned := false
+	if fallback, err := d.docker.InspectImage(fmt.Sprintf(d.imageName, "fallback")); err == nil && d.commitToFallback {
+		pinned = d.isPinned(fallback)
+	}
+	_, err = d.docker.CommitContainer(docker.CommitContainerOptions{Container: containerID, Repository: repository, Tag: tag})
+	d.destroy(containerID)
+	if err != nil {
+		return err
+	}
+	d.logFile.WriteString(fmt.Sprintf("\n\n---Builder image %s:%s---\n", repository, tag))
+	if err := d.docker.TagImage(repository+":"+tag, docker.TagImageOptions{Repo: repository, Tag: suffix, Force: true}); err != nil {
+		return err
+	}
+	if pinned {
+		if err := d.docker.TagImage(repository+":"+tag, docker.TagImageOptions{Repo: repository, Tag: pinnedTag, Force: true}); err != nil {
+			return err
+		}
+	}
+	d.pruneImages(repository)
+	return nil
+}
+
+//pruneImages keep the docker.images.keep (10 by default) most recent versions of the builder image
+//A failure is only logged, the update succeeded
+func (d *DockerWorker) pruneImages(repository string) {
+	removed, err := pruneBuilderImages(d.docker, repository, revel.Config.IntDefault("docker.images.keep", 10))
+	for _, tag := range removed {
+		d.logFile.WriteString(fmt.Sprintf("Removed builder image %s:%s\n", repository, tag))
+	}
+	if err != nil {
+		d.logFile.WriteString(fmt.Sprintf("Builder images of %s not pruned: %s\n", repository, err))
+		revel.WARN.Println(err)
+	}
 }
 
 //UpdateOrFallback to good docker image
 func (d *DockerWorker) buildProject(fallBack bool) error {
 
+	instructions := d.build.BuildInstructions()
+
+	//Build parameters are given as GOGOBUILD_* environment variables (see Build.Env)
+	//Each instruction is recorded as a Step (see stepScript)
 	var cmds []string
 	cmds = append(cmds, "bash")
 	cmds = append(cmds, "-c")
-
-	re := regexp.MustCompile("{{REF_NUMBER}}")
-	releaseNumber := regexp.MustCompile("{{RELEASE_NUMBER}}")
-	releaseString := d.build.Date.Format("20060102150400") + "~git" + d.build.GitCommitID
-
-	cmds = append(cmds, releaseNumber.ReplaceAllString(
-		re.ReplaceAllString(strings.Join(d.build.ProjectToBuild.Configuration.BuildInstructions[d.targetSys], " && "), d.build.Commit),
-		releaseString))
-	d.logFile.WriteString(strings.Join(cmds, "\n"))
+	cmds = append(cmds, stepScript(instructions))
+	d.logFile.WriteString(strings.Join(instructions, "\n"))
 	d.logFile.WriteString("\n\n ---OUTPUT---- \n")
 
 	var suffix string
@@ -270,10 +486,12 @@ func (d *DockerWorker) buildProject(fallBack bool) error {
 		AttachStderr: false,
 		Tty:          false,
 		Cmd:          cmds,
+		Env:          d.build.Env(),
 		Image:        fmt.Sprintf(d.imageName, suffix),
+		Labels:       d.containerLabels("build", suffix),
 	}
-	sourceDir := fmt.Sprintf("%s/%s/%s:/%s", revel.BasePath, "public/projects/", d.build.ProjectToBuild.Name, d.build.ProjectToBuild.Name)
-	hostConfig := &docker.HostConfig{Binds: []string{d.outputDir + ":/output", sourceDir}}
+	//The project is at /<project name>, the output goes to /output (mounted or copied, see mounts)
+	hostConfig := &docker.HostConfig{Binds: d.mounts(true)}
 	containerConfig := docker.CreateContainerOptions{
 		Config:     config,
 		HostConfig: hostConfig,
@@ -284,14 +502,14 @@ func (d *DockerWorker) buildProject(fallBack bool) error {
 		log.Println(err)
 		return err
 	}
-
-	logOptions := docker.LogsOptions{
-		Stdout:       true,
-		Stderr:       true,
-		Timestamps:   true,
-		Container:    container.ID,
-		OutputStream: d.logFile,
-		ErrorStream:  d.logFile,
+	if err := d.setContainer(container.ID); err != nil {
+		return err
+	}
+	defer d.setContainer("")
+	if err := d.upload(container.ID, true); err != nil {
+		d.logFile.WriteString("\n" + err.Error())
+		d.destroy(container.ID)
+		return err
 	}
 
 	// Start the container
@@ -301,23 +519,51 @@ func (d *DockerWorker) buildProject(fallBack bool) error {
 		log.Println(err)
 		return err
 	}
+	return d.waitProject(container.ID, suffix)
+}
 
-	d.build.State = Building
-	BMInstance().UpdateBuild(&d.build)
+//waitProject wait for the build container and log the result
+func (d *DockerWorker) waitProject(containerID string, image string) error {
+	BMInstance().StartBuild(&d.build)
+
+	steps := &stepWriter{
+		build:    &d.build,
+		log:      d.logFile,
+		image:    image,
+		commands: d.build.BuildInstructions(),
+	}
+	logDone := d.followLogs(containerID, steps)
 
 	//Wait for the container to do it's work
-	retValue, err := d.docker.WaitContainer(container.ID)
+	retValue, err := d.docker.WaitContainer(containerID)
 
-	//Copy the log to the logFile
-	errLog := d.docker.Logs(logOptions)
+	//Wait for the end of the log stream
+	errLog := <-logDone
 	if errLog != nil {
 		log.Println(errLog.Error())
 	}
 
-	//Remove the container
-	d.destroy(container.ID)
+	//Get the output back from a host not sharing the server files, then remove the container
+	errOutput := d.download(containerID)
+	if errOutput != nil {
+		d.logFile.WriteString("\nOUTPUT: " + errOutput.Error() + "\n")
+	}
+	d.destroy(containerID)
+
+	//The step running when the container stopped did not get to its end marker
+	stepState := Fail
+	if d.isStopped() {
+		stepState = d.stoppedState()
+	}
+	if err := steps.close(stepState); err != nil {
+		log.Println(err)
+	}
 
-	if err != nil || retValue != 0 {
+	if d.isStopped() {
+		d.logFile.WriteString("\nBUILD " + strings.ToUpper(d.stoppedState().String()) + "\n")
+		return fmt.Errorf("Build %s", d.stoppedState())
+	}
+	if err != nil || retValue != 0 || errOutput != nil {
 		d.logFile.WriteString("\nBUILD FAILED\n")
 		return errors.New("Build failed")
 	}
@@ -325,7 +571,117 @@ func (d *DockerWorker) buildProject(fallBack bool) error {
 	return nil
 }
 
+//followLogs copy the container output to the log as it is produced
+//The returned channel receive the result once the container stopped
+func (d *DockerWorker) followLogs(containerID string, output io.Writer) <-chan error {
+	done := make(chan error, 1)
+	logOptions := docker.LogsOptions{
+		Follow:       true,
+		Since:        d.logsSince,
+		Stdout:       true,
+		Stderr:       true,
+		Timestamps:   true,
+		Container:    containerID,
+		OutputStream: output,
+		ErrorStream:  output,
+	}
+	go func() {
+		done <- d.docker.Logs(logOptions)
+	}()
+	return done
+}
+
+//containerLabels identify the build, step and image of a container
+func (d *DockerWorker) containerLabels(step string, image string) map[string]string {
+	return map[string]string{
+		buildLabel: d.build.ID.Hex(),
+		stepLabel:  step,
+		imageLabel: image,
+	}
+}
+
+//Reattach look for the container left by a previous instance of the server
+//on every docker host. Run will then wait for it instead of starting the build again
+func (d *DockerWorker) Reattach() error {
+	host, containers, err := HPInstance().FindContainer(&d.build)
+	if err != nil {
+		return err
+	}
+	d.host = host
+	if err := d.init(); err != nil {
+		return err
+	}
+	//Most recent first
+	d.resumeContainer = &containers[0]
+	return nil
+}
+
 //Destroy docker image
 func (d *DockerWorker) destroy(containerID string) {
 	d.docker.RemoveContainer(docker.RemoveContainerOptions{ID: containerID, Force: true, RemoveVolumes: false})
 }
+
+//Cancel the build, the running container is killed and removed
+func (d *DockerWorker) Cancel() {
+	d.stop(Cancelled)
+}
+
+//stop the build with the given final state (Cancelled, TimedOut)
+//The running container is killed and removed
+func (d *DockerWorker) stop(state State) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.stopped {
+		return
+	}
+	d.stopped = true
+	d.stopState = state
+	log.Printf("Stopping build %s: %s", d.build.ID.Hex(), state)
+	if d.cancelImage != nil {
+		d.cancelImage()
+	}
+	if len(d.containerID) > 0 {
+		err := d.docker.KillContainer(docker.KillContainerOptions{ID: d.containerID})
+		if err != nil {
+			log.Println(err)
+		}
+		d.destroy(d.containerID)
+	}
+}
+
+func (d *DockerWorker) isStopped() bool {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.stopped
+}
+
+func (d *DockerWorker) stoppedState() State {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.stopState
+}
+
+//setCancelImage record how to cancel the running image build
+//An error is returned if the build was already stopped
+func (d *DockerWorker) setCancelImage(cancel context.CancelFunc) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.stopped && cancel != nil {
+		return fmt.Errorf("Build %s", d.stopState)
+	}
+	d.cancelImage = cancel
+	return nil
+}
+
+//setContainer record the running container so it can be stopped
+//The container is destroyed right away if the build was already stopped
+func (d *DockerWorker) setContainer(containerID string) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.stopped && len(containerID) > 0 {
+		d.destroy(containerID)
+		return fmt.Errorf("Build %s", d.stopState)
+	}
+	d.containerID = containerID
+	return nil
+}
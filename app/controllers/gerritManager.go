@@ -3,7 +3,11 @@ package controllers
 import (
 	"fmt"
 	"log"
+	"net/http"
+	"net/mail"
+	"strings"
 
+	"github.com/revel/revel"
 	"golang.org/x/build/gerrit"
 )
 
@@ -11,26 +15,128 @@ import (
 type GerritManager struct {
 	gerritClient *gerrit.Client
 	project      string
+	labels       map[string]map[string]int
 }
 
 //Init function
+//Reviews are posted with the gerrit.user and gerrit.password (HTTP password) of app.conf
 func (g *GerritManager) Init(p *Project) {
-	g.gerritClient = gerrit.NewClient(p.Configuration.ReviewAddress, nil)
+	var auth gerrit.Auth
+	if user := revel.Config.StringDefault("gerrit.user", ""); len(user) > 0 {
+		auth = gerrit.BasicAuth(user, revel.Config.StringDefault("gerrit.password", ""))
+	}
+	g.gerritClient = gerrit.NewClient(p.Configuration.ReviewAddress, auth)
+	g.gerritClient.HTTPClient = &http.Client{Timeout: reviewTimeout}
 	g.project = p.Name
+	g.labels = p.Configuration.ReviewLabels
 }
 
 //GetOpenChanges return open gerrit patchset for project
 //TODO: Use the Mergable and title property ??
-func (g *GerritManager) GetOpenChanges() ([]string, error) {
+func (g *GerritManager) GetOpenChanges() ([]Change, error) {
 	query := fmt.Sprintf("project:%s status:open", g.project)
 	changes, err := g.gerritClient.QueryChanges(query, gerrit.QueryChangesOpt{N: 0, Fields: []string{"current_revision"}})
 	if err != nil {
 		log.Println(err.Error())
 		return nil, err
 	}
-	var changeNumbers []string
+	var openChanges []Change
 	for _, change := range changes {
-		changeNumbers = append(changeNumbers, fmt.Sprintf("%s", change.Revisions[change.CurrentRevision].Ref))
+		openChanges = append(openChanges, Change{Ref: change.Revisions[change.CurrentRevision].Ref, SHA: change.CurrentRevision})
+	}
+	return openChanges, nil
+}
+
+//ReportBuild post a review on the change built with the labels configured for the build state
+//The vote is posted once the builds of every sys of the patchset are final, with the worst result,
+//until then only a message is posted so a later sys does not overwrite the vote of a failed one
+//Nothing is posted for running builds, if the project has no ReviewLabels or if the build is not a change ref
+func (g *GerritManager) ReportBuild(build *Build) error {
+	if len(g.labels) == 0 || build.State.IsRunning() {
+		return nil
+	}
+	changeNumber, patchSet, ok := parseChangeRef(build.Commit)
+	if !ok {
+		return nil
+	}
+	message := fmt.Sprintf("GoGo Build %s for %s: %s", build.ProjectToBuild.Name, build.TargetSys, build.State)
+	if url := build.URL(); len(url) > 0 {
+		message += "\n\n" + url
+	}
+	review := gerrit.ReviewInput{Message: message}
+	builds, err := BMInstance().GetBuildsByProjects(build.ProjectToBuild.Name)
+	if err != nil {
+		return err
+	}
+	if state, final := patchsetResult(builds, build.Commit); final {
+		review.Labels = g.labels[state.String()]
+	}
+	return g.gerritClient.SetReview(changeNumber, patchSet, review)
+}
+
+//patchsetResult return the worst state of the latest build of each sys of ref
+//final is false while one of them is still queued or running
+func patchsetResult(builds []Build, ref string) (State, bool) {
+	latest := make(map[string]Build)
+	for _, build := range builds {
+		if build.Commit != ref {
+			continue
+		}
+		if previous, found := latest[build.TargetSys]; !found || build.Date.After(previous.Date) {
+			latest[build.TargetSys] = build
+		}
+	}
+	if len(latest) == 0 {
+		return Created, false
+	}
+	worst := Success
+	for _, build := range latest {
+		if build.State.IsRunning() {
+			return build.State, false
+		}
+		if stateSeverity(build.State) > stateSeverity(worst) {
+			worst = build.State
+		}
+	}
+	return worst, true
+}
+
+//stateSeverity order the final states from the best to the worst result
+func stateSeverity(s State) int {
+	switch s {
+	case Success:
+		return 0
+	case FallbackSuccess:
+		return 1
+	case Cancelled:
+		return 2
+	case TimedOut:
+		return 3
+	}
+	return 4
+}
+
+//GetOwner return the address of the change owner
+func (g *GerritManager) GetOwner(ref string) (*mail.Address, error) {
+	changeNumber, _, ok := parseChangeRef(ref)
+	if !ok {
+		return nil, nil
+	}
+	changes, err := g.gerritClient.QueryChanges("change:"+changeNumber, gerrit.QueryChangesOpt{N: 1, Fields: []string{"DETAILED_ACCOUNTS"}})
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 || changes[0].Owner == nil || len(changes[0].Owner.Email) == 0 {
+		return nil, fmt.Errorf("No owner email for change %s", changeNumber)
+	}
+	return &mail.Address{Name: changes[0].Owner.Name, Address: changes[0].Owner.Email}, nil
+}
+
+//parseChangeRef split a change ref (e.g refs/changes/45/12345/3) in change number and patchset
+func parseChangeRef(ref string) (string, string, bool) {
+	parts := strings.Split(ref, "/")
+	if len(parts) != 5 || parts[0] != "refs" || parts[1] != "changes" {
+		return "", "", false
 	}
-	return changeNumbers, nil
+	return parts[3], parts[4], true
 }
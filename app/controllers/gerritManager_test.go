@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPatchsetResult(t *testing.T) {
+	now := time.Now()
+	ref := "refs/changes/45/12345/3"
+	build := func(sys string, state State, age time.Duration) Build {
+		return Build{TargetSys: sys, Commit: ref, State: state, Date: now.Add(-age)}
+	}
+	tests := []struct {
+		name   string
+		builds []Build
+		state  State
+		final  bool
+	}{
+		{"no build", nil, Created, false},
+		{"all succeeded", []Build{build("win32", Success, 0), build("linux", Success, 0)}, Success, true},
+		{"one sys still running", []Build{build("win32", Fail, 0), build("linux", Building, 0)}, Building, false},
+		{"fail wins over a later success", []Build{build("win32", Fail, time.Minute), build("linux", Success, 0)}, Fail, true},
+		{"fallback is worse than success", []Build{build("win32", FallbackSuccess, 0), build("linux", Success, 0)}, FallbackSuccess, true},
+		{"only the latest build of a sys counts", []Build{build("win32", Fail, time.Hour), build("win32", Success, 0)}, Success, true},
+		{"other refs are ignored", []Build{build("win32", Success, 0), {TargetSys: "linux", Commit: "refs/changes/45/12345/2", State: Fail, Date: now}}, Success, true},
+	}
+	for _, test := range tests {
+		state, final := patchsetResult(test.builds, ref)
+		if state != test.state || final != test.final {
+			t.Errorf("%s: got %s, %v, expected %s, %v", test.name, state, final, test.state, test.final)
+		}
+	}
+}
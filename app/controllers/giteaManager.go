@@ -0,0 +1,23 @@
+package controllers
+
+import (
+	"github.com/revel/revel"
+)
+
+//GiteaManager list the open pull requests and report commit statuses
+//Gitea API follow the GitHub one under /api/v1
+//ReviewAddress is the Gitea address and ReviewRepository the owner/repo
+type GiteaManager struct {
+	GitHubManager
+}
+
+//Init function
+//The gitea.token of app.conf is used to authenticate
+func (g *GiteaManager) Init(p *Project) {
+	g.GitHubManager.Init(p)
+	g.prefix = "/api/v1"
+	g.api.authValue = ""
+	if token := revel.Config.StringDefault("gitea.token", ""); len(token) > 0 {
+		g.api.authValue = "token " + token
+	}
+}
@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGiteaGetOpenChangesAndStatus(t *testing.T) {
+	var statuses []commitStatus
+	server := newGitHubStandIn(t, "/api/v1", &statuses)
+	defer server.Close()
+
+	g := GiteaManager{GitHubManager{api: reviewAPI{baseURL: server.URL, authHeader: "Authorization", authValue: "token secret"}, prefix: "/api/v1", repo: "owner/repo"}}
+	changes, err := g.GetOpenChanges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{{"refs/pull/12/head", "aaa"}, {"refs/pull/7/head", "bbb"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("got %v, expected %v", changes, expected)
+	}
+
+	status := commitStatus{State: githubState(Success), Context: "gogobuild/win32"}
+	if err := g.setStatus("bbb", status); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].State != "success" {
+		t.Errorf("unexpected statuses %v", statuses)
+	}
+}
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"fmt"
+	"net/mail"
+
+	"github.com/revel/revel"
+)
+
+//GitHubManager list the open pull requests and report commit statuses
+//ReviewAddress is the API address (e.g https://api.github.com) and
+//ReviewRepository the owner/repo (the project name by default)
+type GitHubManager struct {
+	api    reviewAPI
+	prefix string
+	repo   string
+}
+
+//commitStatus is the status posted on a commit (GitHub and Gitea)
+type commitStatus struct {
+	State       string `json:"state"`
+	TargetURL   string `json:"target_url,omitempty"`
+	Description string `json:"description,omitempty"`
+	Context     string `json:"context"`
+}
+
+type githubPull struct {
+	Number int `json:"number"`
+	Head   struct {
+		SHA string `json:"sha"`
+	} `json:"head"`
+	User githubUser `json:"user"`
+}
+
+//githubUser is the author of a pull request, Gitea name it full_name and always give the email
+type githubUser struct {
+	Login    string `json:"login"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Email    string `json:"email"`
+}
+
+//Init function
+//The github.token of app.conf is used to authenticate
+func (g *GitHubManager) Init(p *Project) {
+	g.api = reviewAPI{baseURL: p.Configuration.ReviewAddress, authHeader: "Authorization"}
+	if token := revel.Config.StringDefault("github.token", ""); len(token) > 0 {
+		g.api.authValue = "token " + token
+	}
+	g.repo = p.Configuration.ReviewRepository
+	if len(g.repo) == 0 {
+		g.repo = p.Name
+	}
+}
+
+//GetOpenChanges return the head refs and commits of the open pull requests (first 100)
+func (g *GitHubManager) GetOpenChanges() ([]Change, error) {
+	var pulls []githubPull
+	err := g.api.do("GET", fmt.Sprintf("%s/repos/%s/pulls?state=open&per_page=100", g.prefix, g.repo), nil, &pulls)
+	if err != nil {
+		revel.WARN.Println(err)
+		return nil, err
+	}
+	var changes []Change
+	for _, pull := range pulls {
+		changes = append(changes, Change{Ref: fmt.Sprintf("refs/pull/%d/head", pull.Number), SHA: pull.Head.SHA})
+	}
+	return changes, nil
+}
+
+//ReportBuild set the status of the commit built, pending while the build runs
+//Builds without a recorded commit use the current head of the pull request
+func (g *GitHubManager) ReportBuild(build *Build) error {
+	number, ok := parsePullRef(build.Commit, "refs/pull/")
+	if !ok {
+		return nil
+	}
+	sha := build.GitCommitID
+	if len(sha) == 0 {
+		var pull githubPull
+		if err := g.api.do("GET", fmt.Sprintf("%s/repos/%s/pulls/%s", g.prefix, g.repo, number), nil, &pull); err != nil {
+			return err
+		}
+		sha = pull.Head.SHA
+	}
+	return g.setStatus(sha, commitStatus{
+		State:       githubState(build.State),
+		TargetURL:   build.URL(),
+		Description: statusDescription(build),
+		Context:     statusContext(build),
+	})
+}
+
+//GetOwner return the address of the pull request author, from its public profile
+func (g *GitHubManager) GetOwner(ref string) (*mail.Address, error) {
+	number, ok := parsePullRef(ref, "refs/pull/")
+	if !ok {
+		return nil, nil
+	}
+	var pull githubPull
+	if err := g.api.do("GET", fmt.Sprintf("%s/repos/%s/pulls/%s", g.prefix, g.repo, number), nil, &pull); err != nil {
+		return nil, err
+	}
+	user := pull.User
+	if len(user.Email) == 0 {
+		if err := g.api.do("GET", fmt.Sprintf("%s/users/%s", g.prefix, user.Login), nil, &user); err != nil {
+			return nil, err
+		}
+	}
+	if len(user.Email) == 0 {
+		return nil, fmt.Errorf("No public email for %s", user.Login)
+	}
+	name := user.Name
+	if len(name) == 0 {
+		name = user.FullName
+	}
+	return &mail.Address{Name: name, Address: user.Email}, nil
+}
+
+func (g *GitHubManager) setStatus(sha string, status commitStatus) error {
+	return g.api.do("POST", fmt.Sprintf("%s/repos/%s/statuses/%s", g.prefix, g.repo, sha), status, nil)
+}
+
+func githubState(s State) string {
+	switch s {
+	case Success, FallbackSuccess:
+		return "success"
+	case Fail, TimedOut:
+		return "failure"
+	case Cancelled:
+		return "error"
+	}
+	return "pending"
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+//newGitHubStandIn serve the pulls and statuses endpoints of the GitHub API under prefix
+func newGitHubStandIn(t *testing.T, prefix string, statuses *[]commitStatus) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(prefix+"/repos/owner/repo/pulls", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("state") != "open" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		w.Write([]byte(`[{"number": 12, "head": {"sha": "aaa"}}, {"number": 7, "head": {"sha": "bbb"}}]`))
+	})
+	mux.HandleFunc(prefix+"/repos/owner/repo/pulls/7", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"number": 7, "head": {"sha": "bbb"}, "user": {"login": "alice"}}`))
+	})
+	mux.HandleFunc(prefix+"/users/alice", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"login": "alice", "name": "Alice", "email": "alice@example.com"}`))
+	})
+	mux.HandleFunc(prefix+"/repos/owner/repo/statuses/bbb", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Authorization") != "token secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var status commitStatus
+		if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+			t.Error(err)
+		}
+		*statuses = append(*statuses, status)
+		w.WriteHeader(http.StatusCreated)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestGitHubGetOpenChanges(t *testing.T) {
+	server := newGitHubStandIn(t, "", nil)
+	defer server.Close()
+
+	g := GitHubManager{api: reviewAPI{baseURL: server.URL}, repo: "owner/repo"}
+	changes, err := g.GetOpenChanges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{{"refs/pull/12/head", "aaa"}, {"refs/pull/7/head", "bbb"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("got %v, expected %v", changes, expected)
+	}
+}
+
+func TestGitHubSetStatus(t *testing.T) {
+	var statuses []commitStatus
+	server := newGitHubStandIn(t, "", &statuses)
+	defer server.Close()
+
+	g := GitHubManager{api: reviewAPI{baseURL: server.URL, authHeader: "Authorization", authValue: "token secret"}, repo: "owner/repo"}
+	status := commitStatus{State: githubState(Fail), TargetURL: "http://gogobuild/build", Context: "gogobuild/win32"}
+	if err := g.setStatus("bbb", status); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0] != status || statuses[0].State != "failure" {
+		t.Errorf("unexpected statuses %v", statuses)
+	}
+
+	g.api.authValue = ""
+	if err := g.setStatus("bbb", status); err == nil {
+		t.Error("expected an error without token")
+	}
+}
+
+func TestGitHubReportBuild(t *testing.T) {
+	var posted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/repos/owner/repo/pulls/7":
+			w.Write([]byte(`{"number": 7, "head": {"sha": "bbb"}}`))
+		case r.Method == "POST":
+			var status commitStatus
+			json.NewDecoder(r.Body).Decode(&status)
+			posted = append(posted, r.URL.Path+" "+status.State)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	g := GitHubManager{api: reviewAPI{baseURL: server.URL}, repo: "owner/repo"}
+	//The recorded commit is used even if the pull request head moved since
+	builds := []Build{
+		{Commit: "refs/pull/7/head", GitCommitID: "aaa", TargetSys: "win32", State: Building},
+		{Commit: "refs/pull/7/head", GitCommitID: "aaa", TargetSys: "win32", State: Success},
+		{Commit: "refs/pull/7/head", TargetSys: "win32", State: Fail},
+		{Commit: "master", GitCommitID: "ccc", TargetSys: "win32", State: Fail},
+	}
+	for i := range builds {
+		if err := g.ReportBuild(&builds[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []string{
+		"/repos/owner/repo/statuses/aaa pending",
+		"/repos/owner/repo/statuses/aaa success",
+		"/repos/owner/repo/statuses/bbb failure",
+	}
+	if !reflect.DeepEqual(posted, expected) {
+		t.Errorf("got %v, expected %v", posted, expected)
+	}
+}
+
+func TestGitHubGetOwner(t *testing.T) {
+	server := newGitHubStandIn(t, "", nil)
+	defer server.Close()
+
+	g := GitHubManager{api: reviewAPI{baseURL: server.URL}, repo: "owner/repo"}
+	owner, err := g.GetOwner("refs/pull/7/head")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner.String() != `"Alice" <alice@example.com>` {
+		t.Errorf("got %s, expected Alice", owner)
+	}
+	if owner, err := g.GetOwner("master"); owner != nil || err != nil {
+		t.Errorf("got %v, %v for master", owner, err)
+	}
+}
+
+func TestParsePullRef(t *testing.T) {
+	tests := []struct {
+		ref    string
+		prefix string
+		number string
+		ok     bool
+	}{
+		{"refs/pull/42/head", "refs/pull/", "42", true},
+		{"refs/merge-requests/3/head", "refs/merge-requests/", "3", true},
+		{"refs/pull/42/merge", "refs/pull/", "", false},
+		{"refs/changes/45/12345/3", "refs/pull/", "", false},
+		{"master", "refs/pull/", "", false},
+	}
+	for _, test := range tests {
+		number, ok := parsePullRef(test.ref, test.prefix)
+		if number != test.number || ok != test.ok {
+			t.Errorf("parsePullRef(%s) = %s, %t", test.ref, number, ok)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+
+	"github.com/revel/revel"
+)
+
+//GitLabManager list the open merge requests and report commit statuses
+//ReviewAddress is the GitLab address (e.g https://gitlab.com) and
+//ReviewRepository the project path (the project name by default)
+type GitLabManager struct {
+	api     reviewAPI
+	project string
+}
+
+type gitlabMergeRequest struct {
+	IID    int    `json:"iid"`
+	SHA    string `json:"sha"`
+	Author struct {
+		ID int `json:"id"`
+	} `json:"author"`
+}
+
+//gitlabUser, email is only given to admins, public_email when the user chose to show it
+type gitlabUser struct {
+	Username    string `json:"username"`
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	PublicEmail string `json:"public_email"`
+}
+
+//Init function
+//The gitlab.token of app.conf is used to authenticate
+func (g *GitLabManager) Init(p *Project) {
+	g.api = reviewAPI{
+		baseURL:    p.Configuration.ReviewAddress,
+		authHeader: "PRIVATE-TOKEN",
+		authValue:  revel.Config.StringDefault("gitlab.token", ""),
+	}
+	repo := p.Configuration.ReviewRepository
+	if len(repo) == 0 {
+		repo = p.Name
+	}
+	g.project = url.QueryEscape(repo)
+}
+
+//GetOpenChanges return the head refs and commits of the open merge requests (first 100)
+func (g *GitLabManager) GetOpenChanges() ([]Change, error) {
+	var mergeRequests []gitlabMergeRequest
+	err := g.api.do("GET", fmt.Sprintf("/api/v4/projects/%s/merge_requests?state=opened&per_page=100", g.project), nil, &mergeRequests)
+	if err != nil {
+		revel.WARN.Println(err)
+		return nil, err
+	}
+	var changes []Change
+	for _, mr := range mergeRequests {
+		changes = append(changes, Change{Ref: fmt.Sprintf("refs/merge-requests/%d/head", mr.IID), SHA: mr.SHA})
+	}
+	return changes, nil
+}
+
+//ReportBuild set the status of the commit built, running while the build runs
+//Builds without a recorded commit use the current head of the merge request
+func (g *GitLabManager) ReportBuild(build *Build) error {
+	iid, ok := parsePullRef(build.Commit, "refs/merge-requests/")
+	if !ok {
+		return nil
+	}
+	sha := build.GitCommitID
+	if len(sha) == 0 {
+		var mr gitlabMergeRequest
+		if err := g.api.do("GET", fmt.Sprintf("/api/v4/projects/%s/merge_requests/%s", g.project, iid), nil, &mr); err != nil {
+			return err
+		}
+		sha = mr.SHA
+	}
+	status := url.Values{}
+	status.Set("state", gitlabState(build.State))
+	status.Set("target_url", build.URL())
+	status.Set("description", statusDescription(build))
+	status.Set("name", statusContext(build))
+	return g.setStatus(sha, status)
+}
+
+//GetOwner return the address of the merge request author
+func (g *GitLabManager) GetOwner(ref string) (*mail.Address, error) {
+	iid, ok := parsePullRef(ref, "refs/merge-requests/")
+	if !ok {
+		return nil, nil
+	}
+	var mr gitlabMergeRequest
+	if err := g.api.do("GET", fmt.Sprintf("/api/v4/projects/%s/merge_requests/%s", g.project, iid), nil, &mr); err != nil {
+		return nil, err
+	}
+	var user gitlabUser
+	if err := g.api.do("GET", fmt.Sprintf("/api/v4/users/%d", mr.Author.ID), nil, &user); err != nil {
+		return nil, err
+	}
+	address := user.PublicEmail
+	if len(address) == 0 {
+		address = user.Email
+	}
+	if len(address) == 0 {
+		return nil, fmt.Errorf("No public email for %s", user.Username)
+	}
+	return &mail.Address{Name: user.Name, Address: address}, nil
+}
+
+func (g *GitLabManager) setStatus(sha string, status url.Values) error {
+	return g.api.do("POST", fmt.Sprintf("/api/v4/projects/%s/statuses/%s?%s", g.project, sha, status.Encode()), nil, nil)
+}
+
+func gitlabState(s State) string {
+	switch s {
+	case Success, FallbackSuccess:
+		return "success"
+	case Fail, TimedOut:
+		return "failed"
+	case Cancelled:
+		return "canceled"
+	}
+	return "running"
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestGitLabGetOpenChangesAndStatus(t *testing.T) {
+	var statuses []url.Values
+	//Route on the escaped path like GitLab does, the project path is a single segment
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/api/v4/projects/group%2Fproject/merge_requests":
+			if r.URL.Query().Get("state") != "opened" {
+				t.Errorf("unexpected query %s", r.URL.RawQuery)
+			}
+			w.Write([]byte(`[{"iid": 5, "sha": "aaa"}, {"iid": 2, "sha": "bbb"}]`))
+		case "/api/v4/projects/group%2Fproject/merge_requests/2":
+			w.Write([]byte(`{"iid": 2, "sha": "bbb"}`))
+		case "/api/v4/projects/group%2Fproject/statuses/bbb":
+			if r.Method != "POST" || r.Header.Get("PRIVATE-TOKEN") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			statuses = append(statuses, r.URL.Query())
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	g := GitLabManager{
+		api:     reviewAPI{baseURL: server.URL, authHeader: "PRIVATE-TOKEN", authValue: "secret"},
+		project: url.QueryEscape("group/project"),
+	}
+	changes, err := g.GetOpenChanges()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{{"refs/merge-requests/5/head", "aaa"}, {"refs/merge-requests/2/head", "bbb"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("got %v, expected %v", changes, expected)
+	}
+
+	status := url.Values{}
+	status.Set("state", gitlabState(Fail))
+	status.Set("name", "gogobuild/win32")
+	if err := g.setStatus("bbb", status); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].Get("state") != "failed" || statuses[0].Get("name") != "gogobuild/win32" {
+		t.Errorf("unexpected statuses %v", statuses)
+	}
+}
@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"path"
+	"strings"
+)
+
+//HooksConfiguration decide which webhook events start builds
+//Branches are glob patterns (e.g release/*) of the pushed branches to build, master by default.
+//Deploy is set on the builds started by a push on master.
+type HooksConfiguration struct {
+	Branches []string
+	Deploy   bool
+}
+
+//BuildBranch return true if a push on branch should be built
+func (h HooksConfiguration) BuildBranch(branch string) bool {
+	branches := h.Branches
+	if len(branches) == 0 {
+		branches = []string{"master"}
+	}
+	for _, pattern := range branches {
+		if ok, _ := path.Match(pattern, branch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+//HookEvent is what a webhook asks to build
+//Ref is empty when the event doesn't need a build
+type HookEvent struct {
+	Ref    string
+	Branch string
+	IsPush bool
+}
+
+//verifyHookSignature check the payload signature with the project secret
+//GitHub (and gerrit through a signing proxy) send a X-Hub-Signature-256 (or sha1 X-Hub-Signature),
+//Gitea a X-Gitea-Signature and GitLab only send back the secret in X-Gitlab-Token
+func verifyHookSignature(provider string, secret []byte, header http.Header, body []byte) bool {
+	switch provider {
+	case "github", "gerrit":
+		if signature := header.Get("X-Hub-Signature-256"); len(signature) > 0 {
+			return checkHMAC(sha256.New, secret, body, strings.TrimPrefix(signature, "sha256="))
+		}
+		if signature := header.Get("X-Hub-Signature"); len(signature) > 0 {
+			return checkHMAC(sha1.New, secret, body, strings.TrimPrefix(signature, "sha1="))
+		}
+	case "gitea":
+		return checkHMAC(sha256.New, secret, body, header.Get("X-Gitea-Signature"))
+	case "gitlab":
+		return subtle.ConstantTimeCompare([]byte(header.Get("X-Gitlab-Token")), secret) == 1
+	}
+	return false
+}
+
+func checkHMAC(h func() hash.Hash, secret []byte, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(h, secret)
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+//parseHookEvent read the ref to build from a webhook payload
+func parseHookEvent(provider string, header http.Header, body []byte) (HookEvent, error) {
+	var payload struct {
+		//push (GitHub, Gitea, GitLab)
+		Ref     string `json:"ref"`
+		Deleted bool   `json:"deleted"`
+		After   string `json:"after"`
+		//pull request (GitHub, Gitea)
+		Action string `json:"action"`
+		Number int    `json:"number"`
+		//merge request (GitLab)
+		ObjectAttributes struct {
+			IID    int    `json:"iid"`
+			Action string `json:"action"`
+		} `json:"object_attributes"`
+		//Gerrit events
+		Type     string `json:"type"`
+		PatchSet struct {
+			Ref string `json:"ref"`
+		} `json:"patchSet"`
+		RefUpdate struct {
+			RefName string `json:"refName"`
+			NewRev  string `json:"newRev"`
+		} `json:"refUpdate"`
+	}
+	var event HookEvent
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return event, err
+	}
+
+	switch provider {
+	case "github", "gitea":
+		eventType := header.Get("X-GitHub-Event")
+		if provider == "gitea" {
+			eventType = header.Get("X-Gitea-Event")
+		}
+		switch eventType {
+		case "push":
+			if !payload.Deleted {
+				event = pushEvent(payload.Ref)
+			}
+		case "pull_request":
+			if payload.Action == "opened" || payload.Action == "synchronize" || payload.Action == "synchronized" || payload.Action == "reopened" {
+				event.Ref = fmt.Sprintf("refs/pull/%d/head", payload.Number)
+			}
+		}
+	case "gitlab":
+		switch header.Get("X-Gitlab-Event") {
+		case "Push Hook":
+			if strings.Trim(payload.After, "0") != "" {
+				event = pushEvent(payload.Ref)
+			}
+		case "Merge Request Hook":
+			action := payload.ObjectAttributes.Action
+			if action == "open" || action == "update" || action == "reopen" {
+				event.Ref = fmt.Sprintf("refs/merge-requests/%d/head", payload.ObjectAttributes.IID)
+			}
+		}
+	case "gerrit":
+		switch payload.Type {
+		case "patchset-created":
+			event.Ref = payload.PatchSet.Ref
+		case "ref-updated":
+			if strings.Trim(payload.RefUpdate.NewRev, "0") != "" {
+				refName := payload.RefUpdate.RefName
+				if !strings.HasPrefix(refName, "refs/") {
+					refName = "refs/heads/" + refName
+				}
+				event = pushEvent(refName)
+			}
+		}
+	default:
+		return event, fmt.Errorf("Unknown provider %s", provider)
+	}
+	return event, nil
+}
+
+//pushEvent build the pushed branch, master is built as the usual master build
+func pushEvent(ref string) HookEvent {
+	if !strings.HasPrefix(ref, "refs/heads/") {
+		return HookEvent{}
+	}
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+	event := HookEvent{Ref: ref, Branch: branch, IsPush: true}
+	if branch == "master" {
+		event.Ref = "master"
+	}
+	return event
+}
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/revel/revel"
+)
+
+//maxHookPayload is the maximum size of a webhook payload read
+const maxHookPayload = 5 * 1024 * 1024
+
+//HooksController receive the webhooks of the git hosting and review systems
+type HooksController struct {
+	*revel.Controller
+}
+
+//Receive a push, pull request or change event and build it on all the target sys
+//The payload is signed with hooks.<project>.secret of app.conf. The event is accepted (202)
+//before the build is created: it reloads the project and may query the review system,
+//longer than the delivery timeout of the git hosting.
+func (c HooksController) Receive() revel.Result {
+	projectName := c.Params.Get("project")
+	provider := c.Params.Get("provider")
+	project := PMInstance().GetProjectByName(projectName)
+	if len(project.Name) == 0 {
+		return c.NotFound("Unknown project %s", projectName)
+	}
+	secret := revel.Config.StringDefault("hooks."+projectName+".secret", "")
+	if len(secret) == 0 {
+		return c.Forbidden("No hook secret configured for %s", projectName)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxHookPayload))
+	if err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderText(err.Error())
+	}
+	if !verifyHookSignature(provider, []byte(secret), c.Request.Header, body) {
+		return c.Forbidden("Invalid signature")
+	}
+	event, err := parseHookEvent(provider, c.Request.Header, body)
+	if err != nil {
+		c.Response.Status = http.StatusBadRequest
+		return c.RenderText(err.Error())
+	}
+
+	hooks := project.Configuration.Hooks
+	if len(event.Ref) == 0 || event.IsPush && !hooks.BuildBranch(event.Branch) {
+		return c.RenderText("Nothing to build")
+	}
+	deploy := event.Ref == "master" && hooks.Deploy
+	priority := ReviewPriority
+	if event.Ref == "master" {
+		priority = MasterPriority
+	}
+	go func() {
+		if _, err := BMInstance().CreateOrReturnStatusBuild(projectName, "all", event.Ref, deploy, priority); err != nil {
+			revel.ERROR.Printf("Build of %s %s from a hook failed: %s", projectName, event.Ref, err)
+		}
+	}()
+	c.Response.Status = http.StatusAccepted
+	return c.RenderJson(map[string]interface{}{"Project": projectName, "Ref": event.Ref, "Deploy": deploy})
+}
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"testing"
+)
+
+func sign(h func() hash.Hash, secret string, body string) string {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyHookSignature(t *testing.T) {
+	secret := "s3cret"
+	body := `{"ref": "refs/heads/master"}`
+	tests := []struct {
+		name     string
+		provider string
+		header   map[string]string
+		valid    bool
+	}{
+		{"github sha256", "github", map[string]string{"X-Hub-Signature-256": "sha256=" + sign(sha256.New, secret, body)}, true},
+		{"github sha1", "github", map[string]string{"X-Hub-Signature": "sha1=" + sign(sha1.New, secret, body)}, true},
+		{"github wrong secret", "github", map[string]string{"X-Hub-Signature-256": "sha256=" + sign(sha256.New, "other", body)}, false},
+		{"github sha256 checked first", "github", map[string]string{
+			"X-Hub-Signature-256": "sha256=" + sign(sha256.New, "other", body),
+			"X-Hub-Signature":     "sha1=" + sign(sha1.New, secret, body),
+		}, false},
+		{"github not hex", "github", map[string]string{"X-Hub-Signature-256": "sha256=zz"}, false},
+		{"github unsigned", "github", nil, false},
+		{"gerrit signing proxy", "gerrit", map[string]string{"X-Hub-Signature-256": "sha256=" + sign(sha256.New, secret, body)}, true},
+		{"gitea", "gitea", map[string]string{"X-Gitea-Signature": sign(sha256.New, secret, body)}, true},
+		{"gitea github header", "gitea", map[string]string{"X-Hub-Signature-256": "sha256=" + sign(sha256.New, secret, body)}, false},
+		{"gitlab token", "gitlab", map[string]string{"X-Gitlab-Token": secret}, true},
+		{"gitlab wrong token", "gitlab", map[string]string{"X-Gitlab-Token": "s3cre"}, false},
+		{"gitlab no token", "gitlab", nil, false},
+		{"unknown provider", "svn", map[string]string{"X-Gitlab-Token": secret}, false},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		for key, value := range test.header {
+			header.Set(key, value)
+		}
+		if valid := verifyHookSignature(test.provider, []byte(secret), header, []byte(body)); valid != test.valid {
+			t.Errorf("%s: got %v, expected %v", test.name, valid, test.valid)
+		}
+	}
+}
+
+func TestParseHookEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		header   map[string]string
+		body     string
+		event    HookEvent
+	}{
+		{"github push master", "github", map[string]string{"X-GitHub-Event": "push"},
+			`{"ref": "refs/heads/master"}`, HookEvent{Ref: "master", Branch: "master", IsPush: true}},
+		{"github push branch", "github", map[string]string{"X-GitHub-Event": "push"},
+			`{"ref": "refs/heads/release/1.0"}`, HookEvent{Ref: "refs/heads/release/1.0", Branch: "release/1.0", IsPush: true}},
+		{"github branch deleted", "github", map[string]string{"X-GitHub-Event": "push"},
+			`{"ref": "refs/heads/old", "deleted": true}`, HookEvent{}},
+		{"github tag", "github", map[string]string{"X-GitHub-Event": "push"},
+			`{"ref": "refs/tags/v1.0"}`, HookEvent{}},
+		{"github pull request opened", "github", map[string]string{"X-GitHub-Event": "pull_request"},
+			`{"action": "opened", "number": 7}`, HookEvent{Ref: "refs/pull/7/head"}},
+		{"github pull request synchronize", "github", map[string]string{"X-GitHub-Event": "pull_request"},
+			`{"action": "synchronize", "number": 7}`, HookEvent{Ref: "refs/pull/7/head"}},
+		{"github pull request closed", "github", map[string]string{"X-GitHub-Event": "pull_request"},
+			`{"action": "closed", "number": 7}`, HookEvent{}},
+		{"github ping", "github", map[string]string{"X-GitHub-Event": "ping"},
+			`{"zen": "Keep it logically awesome."}`, HookEvent{}},
+		{"gitea pull request synchronized", "gitea", map[string]string{"X-Gitea-Event": "pull_request"},
+			`{"action": "synchronized", "number": 3}`, HookEvent{Ref: "refs/pull/3/head"}},
+		{"gitea push", "gitea", map[string]string{"X-Gitea-Event": "push"},
+			`{"ref": "refs/heads/master"}`, HookEvent{Ref: "master", Branch: "master", IsPush: true}},
+		{"gitlab push", "gitlab", map[string]string{"X-Gitlab-Event": "Push Hook"},
+			`{"ref": "refs/heads/dev", "after": "1a2b3c"}`, HookEvent{Ref: "refs/heads/dev", Branch: "dev", IsPush: true}},
+		{"gitlab branch deleted", "gitlab", map[string]string{"X-Gitlab-Event": "Push Hook"},
+			`{"ref": "refs/heads/dev", "after": "0000000000000000000000000000000000000000"}`, HookEvent{}},
+		{"gitlab merge request update", "gitlab", map[string]string{"X-Gitlab-Event": "Merge Request Hook"},
+			`{"object_attributes": {"iid": 5, "action": "update"}}`, HookEvent{Ref: "refs/merge-requests/5/head"}},
+		{"gitlab merge request merged", "gitlab", map[string]string{"X-Gitlab-Event": "Merge Request Hook"},
+			`{"object_attributes": {"iid": 5, "action": "merge"}}`, HookEvent{}},
+		{"gerrit patchset", "gerrit", nil,
+			`{"type": "patchset-created", "patchSet": {"ref": "refs/changes/45/12345/3"}}`, HookEvent{Ref: "refs/changes/45/12345/3"}},
+		{"gerrit ref updated", "gerrit", nil,
+			`{"type": "ref-updated", "refUpdate": {"refName": "master", "newRev": "1a2b3c"}}`, HookEvent{Ref: "master", Branch: "master", IsPush: true}},
+		{"gerrit ref deleted", "gerrit", nil,
+			`{"type": "ref-updated", "refUpdate": {"refName": "refs/heads/dev", "newRev": "0000000"}}`, HookEvent{}},
+		{"gerrit comment", "gerrit", nil,
+			`{"type": "comment-added"}`, HookEvent{}},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		for key, value := range test.header {
+			header.Set(key, value)
+		}
+		event, err := parseHookEvent(test.provider, header, []byte(test.body))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if event != test.event {
+			t.Errorf("%s: got %+v, expected %+v", test.name, event, test.event)
+		}
+	}
+
+	if _, err := parseHookEvent("github", http.Header{}, []byte("not json")); err == nil {
+		t.Error("expected an error on an invalid payload")
+	}
+	if _, err := parseHookEvent("svn", http.Header{}, []byte("{}")); err == nil {
+		t.Error("expected an error on an unknown provider")
+	}
+}
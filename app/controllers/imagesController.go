@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"github.com/revel/revel"
+)
+
+//ImagesController Controller, history of the builder images of a project sys
+type ImagesController struct {
+	*revel.Controller
+}
+
+//Index page
+func (c ImagesController) Index() revel.Result {
+	project := PMInstance().GetProjectByName(c.Params.Get("project"))
+	sys := c.Params.Get("sys")
+	images, err := ListBuilderImages(project.Name, sys)
+	if err != nil {
+		c.Flash.Error(err.Error())
+	}
+	if c.Params.Get("format") == "json" {
+		return c.RenderJson(images)
+	}
+	hosts := HPInstance().Hosts()
+	return c.Render(project, sys, images, hosts)
+}
+
+//Pin a version of the builder image as the fallback image
+func (c ImagesController) Pin() revel.Result {
+	project, sys, tag, host := c.Params.Get("project"), c.Params.Get("sys"), c.Params.Get("tag"), c.Params.Get("host")
+	if err := PinBuilderImage(project, sys, host, tag); err != nil {
+		c.Flash.Error(err.Error())
+	} else {
+		c.Flash.Success("%s is now the fallback image of %s %s on %s", tag, project, sys, host)
+	}
+	return c.Redirect("/projects/%s/images/%s", project, sys)
+}
+
+//Rebuild the fallback image from its Dockerfile, through a rebuildImage build
+func (c ImagesController) Rebuild() revel.Result {
+	project, sys := c.Params.Get("project"), c.Params.Get("sys")
+	build, _ := BMInstance().CreateOrReturnStatusBuild(project, sys, "rebuildImage", false, ManualPriority)
+	c.Flash.Success("Rebuilding the image of %s %s", project, sys)
+	if c.Params.Get("format") == "json" {
+		return c.RenderJson(build)
+	}
+	return c.Redirect("/projects/%s/builds", project)
+}
+
+//Rollback to the version preceding the fallback image
+func (c ImagesController) Rollback() revel.Result {
+	project, sys, host := c.Params.Get("project"), c.Params.Get("sys"), c.Params.Get("host")
+	if tag, err := RollbackBuilderImage(project, sys, host); err != nil {
+		c.Flash.Error(err.Error())
+	} else {
+		c.Flash.Success("Rolled back %s %s on %s to %s", project, sys, host, tag)
+	}
+	return c.Redirect("/projects/%s/images/%s", project, sys)
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/revel/revel"
+)
+
+//logStreamInterval is the time between two reads of a running build log
+const logStreamInterval = time.Second
+
+//logStreamChunk is the maximum size of a single event
+const logStreamChunk = 64 * 1024
+
+//LogStream is a revel.Result sending a build log as Server-Sent Events
+//The id of each event is the byte offset reached in the log file so a
+//reconnecting client (Last-Event-ID) resume where it stopped.
+//The stream follows the file while the build is running then send an "end" event.
+type LogStream struct {
+	BuildID string
+	Path    string
+	Offset  int64
+}
+
+//Apply the result
+func (l LogStream) Apply(req *revel.Request, resp *revel.Response) {
+	resp.Out.Header().Set("Cache-Control", "no-cache")
+	resp.WriteHeader(http.StatusOK, "text/event-stream")
+	flusher, _ := resp.Out.(http.Flusher)
+
+	offset := l.Offset
+	for {
+		//Check the state before reading so nothing is missed after the last write
+		build, err := BMInstance().GetBuildByID(l.BuildID)
+		finished := err != nil || !build.State.IsRunning()
+
+		sent, err := l.send(resp.Out, offset, finished)
+		if err != nil {
+			//Client is gone
+			return
+		}
+		offset += sent
+		if finished {
+			fmt.Fprintf(resp.Out, "event: end\ndata: %s\n\n", build.State)
+		} else if sent == 0 {
+			//Keep alive, also detect closed connections
+			_, err = io.WriteString(resp.Out, ":\n\n")
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if finished || err != nil {
+			return
+		}
+		time.Sleep(logStreamInterval)
+	}
+}
+
+//LogRange is a revel.Result sending the Offset to End bytes of a build log as plain text
+type LogRange struct {
+	Path   string
+	Offset int64
+	End    int64
+}
+
+//Apply the result
+func (l LogRange) Apply(req *revel.Request, resp *revel.Response) {
+	if l.Offset < 0 || l.End < l.Offset {
+		resp.WriteHeader(http.StatusBadRequest, "text/plain; charset=utf-8")
+		return
+	}
+	file, err := os.Open(l.Path)
+	if err != nil {
+		resp.WriteHeader(http.StatusNotFound, "text/plain; charset=utf-8")
+		return
+	}
+	defer file.Close()
+	resp.WriteHeader(http.StatusOK, "text/plain; charset=utf-8")
+	io.Copy(resp.Out, io.NewSectionReader(file, l.Offset, l.End-l.Offset))
+}
+
+//send the log content available from offset and return the number of bytes sent
+//Unless final is set, only complete lines are sent
+func (l LogStream) send(w io.Writer, offset int64, final bool) (int64, error) {
+	file, err := os.Open(l.Path)
+	if os.IsNotExist(err) {
+		//Build not started yet
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, 0); err != nil {
+		return 0, err
+	}
+
+	var sent int64
+	buf := make([]byte, logStreamChunk)
+	for {
+		n, err := io.ReadFull(file, buf)
+		if n == 0 {
+			return sent, nil
+		}
+		chunk := buf[:n]
+		//A short read is the end of the file
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if !final || !last {
+			//Keep the partial last line for the next read, unless it fills the whole chunk
+			//Once the build is over, the partial last line of the file is sent
+			if end := bytes.LastIndexByte(chunk, '\n'); end >= 0 {
+				chunk = chunk[:end+1]
+			} else if n < logStreamChunk {
+				return sent, nil
+			}
+		}
+		sent += int64(len(chunk))
+		if err := writeLogEvent(w, offset+sent, chunk); err != nil {
+			return sent, err
+		}
+		if last {
+			return sent, nil
+		}
+		if len(chunk) < n {
+			//Read the held back line again with the next chunk
+			if _, err := file.Seek(offset+sent, 0); err != nil {
+				return sent, err
+			}
+		}
+	}
+}
+
+//writeLogEvent write a chunk of log as one event, one data field per line
+func writeLogEvent(w io.Writer, id int64, chunk []byte) error {
+	var event bytes.Buffer
+	fmt.Fprintf(&event, "id: %d\n", id)
+	for _, line := range bytes.Split(bytes.TrimSuffix(chunk, []byte("\n")), []byte("\n")) {
+		event.WriteString("data: ")
+		event.Write(bytes.TrimSuffix(line, []byte("\r")))
+		event.WriteString("\n")
+	}
+	event.WriteString("\n")
+	_, err := w.Write(event.Bytes())
+	return err
+}
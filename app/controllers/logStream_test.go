@@ -0,0 +1,225 @@
+package controllers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/revel/revel"
+)
+
+//logEvent is an event of the log stream
+type logEvent struct {
+	id    int64
+	lines []string
+}
+
+//parseLogEvents split the output of LogStream.send in events
+func parseLogEvents(t *testing.T, stream string) []logEvent {
+	var events []logEvent
+	for _, block := range strings.Split(strings.TrimSuffix(stream, "\n\n"), "\n\n") {
+		if len(block) == 0 {
+			continue
+		}
+		var event logEvent
+		for _, field := range strings.Split(block, "\n") {
+			switch {
+			case strings.HasPrefix(field, "id: "):
+				id, err := strconv.ParseInt(strings.TrimPrefix(field, "id: "), 10, 64)
+				if err != nil {
+					t.Fatalf("invalid id %q", field)
+				}
+				event.id = id
+			case strings.HasPrefix(field, "data: "):
+				event.lines = append(event.lines, strings.TrimPrefix(field, "data: "))
+			default:
+				t.Fatalf("unexpected field %q", field)
+			}
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+//newTestLog create an empty log file
+func newTestLog(t *testing.T) *os.File {
+	log, err := ioutil.TempFile("", "gogobuild-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return log
+}
+
+func TestLogStreamSend(t *testing.T) {
+	long := strings.Repeat("x", logStreamChunk+10)
+	tests := []struct {
+		name string
+		//Appended to the log before each read, the last read is final
+		writes []string
+		lines  []string
+	}{
+		{
+			name:   "whole lines",
+			writes: []string{"one\ntwo\n", "three\n"},
+			lines:  []string{"one", "two", "three"},
+		},
+		{
+			name:   "line split across writes",
+			writes: []string{"one\ntw", "o\nthr", "ee\n"},
+			lines:  []string{"one", "two", "three"},
+		},
+		{
+			name:   "partial last line sent at the end",
+			writes: []string{"one\npart", "ial"},
+			lines:  []string{"one", "partial"},
+		},
+		{
+			name:   "nothing written",
+			writes: []string{"", ""},
+		},
+		{
+			name:   "carriage returns",
+			writes: []string{"one\r\ntwo\r\n"},
+			lines:  []string{"one", "two"},
+		},
+		{
+			name:   "line split by the chunk size",
+			writes: []string{strings.Repeat("a", logStreamChunk-2) + "\nbcd\n"},
+			lines:  []string{strings.Repeat("a", logStreamChunk-2), "bcd"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := newTestLog(t)
+			defer os.Remove(log.Name())
+			defer log.Close()
+
+			l := LogStream{Path: log.Name()}
+			var offset int64
+			var written int64
+			var lines []string
+			for i, write := range test.writes {
+				if _, err := log.WriteString(write); err != nil {
+					t.Fatal(err)
+				}
+				written += int64(len(write))
+				final := i == len(test.writes)-1
+
+				var out bytes.Buffer
+				sent, err := l.send(&out, offset, final)
+				if err != nil {
+					t.Fatal(err)
+				}
+				//Every event ends the lines sent so far and its id is the offset reached
+				events := parseLogEvents(t, out.String())
+				next := offset
+				for _, event := range events {
+					if event.id <= next || event.id > offset+sent {
+						t.Fatalf("read %d: event id %d not in %d-%d", i, event.id, next, offset+sent)
+					}
+					next = event.id
+					lines = append(lines, event.lines...)
+				}
+				if len(events) > 0 && next != offset+sent {
+					t.Fatalf("read %d: last event id %d, sent up to %d", i, next, offset+sent)
+				}
+				offset += sent
+			}
+			if offset != written {
+				t.Errorf("sent %d bytes of %d", offset, written)
+			}
+			if strings.Join(lines, "|") != strings.Join(test.lines, "|") {
+				t.Errorf("got lines %q, want %q", lines, test.lines)
+			}
+		})
+	}
+
+	t.Run("line longer than a chunk", func(t *testing.T) {
+		log := newTestLog(t)
+		defer os.Remove(log.Name())
+		defer log.Close()
+		log.WriteString(long + "\nend")
+
+		//A line filling a whole chunk is sent in pieces, the partial last line is held back
+		var out bytes.Buffer
+		sent, err := LogStream{Path: log.Name()}.send(&out, 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sent != int64(len(long)+1) {
+			t.Errorf("sent %d bytes, want %d", sent, len(long)+1)
+		}
+		events := parseLogEvents(t, out.String())
+		if len(events) != 2 || events[0].id != logStreamChunk || events[1].id != sent {
+			t.Fatalf("got events %d", len(events))
+		}
+		if got := strings.Join(append(events[0].lines, events[1].lines...), ""); got != long {
+			t.Errorf("got a line of %d bytes, want %d", len(got), len(long))
+		}
+	})
+
+	t.Run("resume at Last-Event-ID", func(t *testing.T) {
+		log := newTestLog(t)
+		defer os.Remove(log.Name())
+		defer log.Close()
+		log.WriteString("one\ntwo\nthree\n")
+
+		var out bytes.Buffer
+		sent, err := LogStream{Path: log.Name()}.send(&out, 4, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != "id: 14\ndata: two\ndata: three\n\n" || sent != 10 {
+			t.Errorf("got %q (%d bytes)", out.String(), sent)
+		}
+	})
+
+	t.Run("build not started", func(t *testing.T) {
+		var out bytes.Buffer
+		sent, err := LogStream{Path: "/nonexistent/gogobuild/log.txt"}.send(&out, 0, true)
+		if err != nil || sent != 0 || out.Len() != 0 {
+			t.Errorf("got %q (%d bytes), %v", out.String(), sent, err)
+		}
+	})
+}
+
+func TestLogRange(t *testing.T) {
+	log := newTestLog(t)
+	defer os.Remove(log.Name())
+	defer log.Close()
+	log.WriteString("step one\nstep two\n")
+
+	tests := []struct {
+		name   string
+		path   string
+		offset int64
+		end    int64
+		status int
+		body   string
+	}{
+		{name: "first step", path: log.Name(), offset: 0, end: 9, status: http.StatusOK, body: "step one\n"},
+		{name: "second step", path: log.Name(), offset: 9, end: 18, status: http.StatusOK, body: "step two\n"},
+		{name: "empty", path: log.Name(), offset: 9, end: 9, status: http.StatusOK},
+		{name: "past the end", path: log.Name(), offset: 14, end: 100, status: http.StatusOK, body: "two\n"},
+		{name: "end before offset", path: log.Name(), offset: 9, end: 2, status: http.StatusBadRequest},
+		{name: "negative offset", path: log.Name(), offset: -1, end: 2, status: http.StatusBadRequest},
+		{name: "no log", path: log.Name() + ".missing", offset: 0, end: 2, status: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			LogRange{Path: test.path, Offset: test.offset, End: test.end}.Apply(nil, &revel.Response{Out: recorder})
+			if recorder.Code != test.status {
+				t.Errorf("got status %d, want %d", recorder.Code, test.status)
+			}
+			if recorder.Body.String() != test.body {
+				t.Errorf("got %q, want %q", recorder.Body.String(), test.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"net/mail"
+
+	"github.com/revel/revel"
+)
+
+//MailController Controller
+type MailController struct {
+	*revel.Controller
+}
+
+//Test send a test mail to the "to" address and report the SMTP error if any
+func (c MailController) Test() revel.Result {
+	to, err := mail.ParseAddress(c.Params.Get("to"))
+	if err == nil {
+		err = MMInstance().SendTest(*to)
+	}
+	if c.Params.Get("format") == "json" {
+		result := map[string]interface{}{"Sent": err == nil}
+		if err != nil {
+			result["Error"] = err.Error()
+		}
+		return c.RenderJson(result)
+	}
+	if err != nil {
+		c.Flash.Error("Test mail failed: %s", err)
+	} else {
+		c.Flash.Success("Test mail sent to %s", to.Address)
+	}
+	return c.Redirect(QueueController.Index)
+}
@@ -1,14 +1,45 @@
 package controllers
 
 import (
+	"crypto/tls"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"mime"
+	"net"
 	"net/mail"
 	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/revel/revel"
 )
 
+//SMTP connection security
+const (
+	SMTPNoTLS    = "none"
+	SMTPStartTLS = "starttls"
+	SMTPTLS      = "tls"
+)
+
+//smtpTimeout bound the connection to the SMTP server
+const smtpTimeout = 30 * time.Second
+
+//SMTPConfiguration is the SMTP server mails are sent through
+//TLS is none (STARTTLS still used if offered), starttls (required) or tls (implicit, usually port 465)
+//Auth is plain, login, cram-md5 or none
+type SMTPConfiguration struct {
+	Server   string
+	Port     int
+	TLS      string
+	Insecure bool
+	Auth     string
+	Username string
+	Password string
+	From     mail.Address
+}
+
 //MailManager is the mail manager
 type MailManager struct {
 }
@@ -24,49 +55,59 @@ func MMInstance() *MailManager {
 	return mmInstance
 }
 
-//SendBuildFailedMail send a mail in case of failed build
-func (m *MailManager) SendBuildFailedMail(b Build) {
-
-	smtpServer := revel.Config.StringDefault("mail.smtp", "")
-	if len(smtpServer) == 0 {
-		revel.WARN.Println("No SMTP server configured in app.conf")
-		return
-	}
-	from := mail.Address{
-		Name:    revel.Config.StringDefault("mail.name", ""),
-		Address: revel.Config.StringDefault("mail.addr", "")}
-	if len(b.ProjectToBuild.Configuration.NotificationMailAdress) < 2 {
-		revel.WARN.Println("NotificationMailAdress mail adress not or mis-configured")
-		return
-	}
-	to := mail.Address{
-		Name:    b.ProjectToBuild.Configuration.NotificationMailAdress[0],
-		Address: b.ProjectToBuild.Configuration.NotificationMailAdress[1]}
-
-	title := fmt.Sprintf("GoGo Build %s/%s Failed", b.ProjectToBuild.Name, b.TargetSys)
-	servAddr := revel.Config.StringDefault("http.addr", "")
-	if len(servAddr) == 0 {
-		revel.WARN.Println("http.addr not configured in app.conf")
-		return
-	}
-	port := revel.Config.StringDefault("http.port", "")
-	if len(port) > 0 {
-		port = ":" + port
-	}
-	//TODO: Use template to allow more flexibility
-	body := fmt.Sprintf("Build <a href='http://%s%s/projects/%s/builds/%s'>%s</a> of project %s for sys %s has failed.",
-		servAddr,
-		port,
-		b.ProjectToBuild.Name,
-		b.ID.Hex(),
-		b.ID.Hex(),
-		b.ProjectToBuild.Name,
-		b.TargetSys)
+//Configuration read the mail.* keys of app.conf
+func (m *MailManager) Configuration() SMTPConfiguration {
+	conf := SMTPConfiguration{
+		Server:   revel.Config.StringDefault("mail.smtp", ""),
+		Port:     revel.Config.IntDefault("mail.port", 25),
+		TLS:      revel.Config.StringDefault("mail.tls", SMTPNoTLS),
+		Insecure: revel.Config.BoolDefault("mail.tls.insecure", false),
+		Username: revel.Config.StringDefault("mail.username", ""),
+		Password: revel.Config.StringDefault("mail.password", ""),
+		From: mail.Address{
+			Name:    revel.Config.StringDefault("mail.name", ""),
+			Address: revel.Config.StringDefault("mail.addr", "")},
+	}
+	//Credentials without a mechanism use PLAIN, the most common one
+	defaultAuth := "none"
+	if len(conf.Username) > 0 {
+		defaultAuth = "plain"
+	}
+	conf.Auth = revel.Config.StringDefault("mail.auth", defaultAuth)
+	return conf
+}
+
+//Send an HTML mail through the SMTP server of app.conf
+func (m *MailManager) Send(to []mail.Address, subject string, body string) error {
+	return sendMail(m.Configuration(), to, subject, body)
+}
+
+//SendTest send a test mail to check the SMTP configuration
+func (m *MailManager) SendTest(to mail.Address) error {
+	return m.Send([]mail.Address{to}, "GoGo Build test mail",
+		"This is a test mail of GoGo Build, the SMTP configuration is working.")
+}
+
+//sendMail send an HTML mail with the given SMTP configuration
+func sendMail(conf SMTPConfiguration, to []mail.Address, subject string, body string) error {
+	if len(conf.Server) == 0 {
+		return errors.New("No SMTP server configured in app.conf")
+	}
+	if len(to) == 0 {
+		return errors.New("No recipient")
+	}
+
+	var recipients []string
+	var toHeader []string
+	for _, address := range to {
+		recipients = append(recipients, address.Address)
+		toHeader = append(toHeader, address.String())
+	}
 
 	header := make(map[string]string)
-	header["From"] = from.String()
-	header["To"] = to.String()
-	header["Subject"] = title
+	header["From"] = conf.From.String()
+	header["To"] = strings.Join(toHeader, ", ")
+	header["Subject"] = mime.QEncoding.Encode("utf-8", subject)
 	header["MIME-Version"] = "1.0"
 	header["Content-Type"] = "text/html; charset=\"UTF-8\""
 	header["Content-Transfer-Encoding"] = "base64"
@@ -77,14 +118,130 @@ func (m *MailManager) SendBuildFailedMail(b Build) {
 	}
 	message += "\r\n" + base64.StdEncoding.EncodeToString([]byte(body))
 
-	err := smtp.SendMail(
-		smtpServer+":25",
-		nil,
-		from.Address,
-		[]string{to.Address},
-		[]byte(message),
-	)
+	client, err := dialSMTP(conf)
 	if err != nil {
-		revel.WARN.Println(err)
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Mail(conf.From.Address); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return fmt.Errorf("Recipient %s: %s", recipient, err)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(message)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+//dialSMTP connect, secure and authenticate the connection to the SMTP server
+func dialSMTP(conf SMTPConfiguration) (*smtp.Client, error) {
+	address := net.JoinHostPort(conf.Server, strconv.Itoa(conf.Port))
+	tlsConfig := &tls.Config{ServerName: conf.Server, InsecureSkipVerify: conf.Insecure}
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+
+	var conn net.Conn
+	var err error
+	switch conf.TLS {
+	case SMTPTLS:
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, tlsConfig)
+	case SMTPStartTLS, SMTPNoTLS, "":
+		conn, err = dialer.Dial("tcp", address)
+	default:
+		return nil, fmt.Errorf("Not a valid mail.tls %s", conf.TLS)
+	}
+	if err != nil {
+		return nil, err
+	}
+	client, err := smtp.NewClient(conn, conf.Server)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if conf.TLS != SMTPTLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				client.Close()
+				return nil, fmt.Errorf("STARTTLS: %s", err)
+			}
+		} else if conf.TLS == SMTPStartTLS {
+			client.Close()
+			return nil, errors.New("The SMTP server does not support STARTTLS")
+		}
+	}
+
+	auth, err := smtpAuth(conf)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	if auth != nil {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			client.Close()
+			return nil, errors.New("The SMTP server does not support authentication")
+		}
+		if err := client.Auth(auth); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("SMTP authentication: %s", err)
+		}
+	}
+	return client, nil
+}
+
+//smtpAuth return the authentication mechanism of mail.auth, nil for none
+func smtpAuth(conf SMTPConfiguration) (smtp.Auth, error) {
+	switch strings.ToLower(conf.Auth) {
+	case "", "none":
+		return nil, nil
+	case "plain":
+		return smtp.PlainAuth("", conf.Username, conf.Password, conf.Server), nil
+	case "login":
+		return &loginAuth{username: conf.Username, password: conf.Password, host: conf.Server}, nil
+	case "cram-md5":
+		return smtp.CRAMMD5Auth(conf.Username, conf.Password), nil
+	}
+	return nil, fmt.Errorf("Not a valid mail.auth %s", conf.Auth)
+}
+
+//loginAuth is the LOGIN mechanism, not in net/smtp but required by some relays (e.g Office 365)
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	//Like PLAIN, never send the password on an unencrypted connection
+	if !server.TLS && server.Name != "localhost" && server.Name != "127.0.0.1" && server.Name != "::1" {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
 	}
+	return nil, fmt.Errorf("Unexpected LOGIN challenge %s", fromServer)
 }
@@ -0,0 +1,294 @@
+package controllers
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//fakeSMTP is a local SMTP server recording the mails it receives
+//It offers STARTTLS if startTLS is set, and accept username/password with AUTH PLAIN or LOGIN
+type fakeSMTP struct {
+	listener net.Listener
+	tls      *tls.Config
+	startTLS bool
+	username string
+	password string
+
+	mutex sync.Mutex
+	mails []fakeMail
+}
+
+type fakeMail struct {
+	From   string
+	To     []string
+	Data   string
+	TLS    bool
+	Authed bool
+}
+
+//testCertificate borrow the self-signed certificate of httptest
+func testCertificate() *tls.Config {
+	server := httptest.NewTLSServer(nil)
+	defer server.Close()
+	return &tls.Config{Certificates: server.TLS.Certificates}
+}
+
+//newFakeSMTP start the server, implicit TLS if implicitTLS is set
+func newFakeSMTP(t *testing.T, implicitTLS bool, startTLS bool, username string, password string) *fakeSMTP {
+	s := &fakeSMTP{tls: testCertificate(), startTLS: startTLS, username: username, password: password}
+	var err error
+	if implicitTLS {
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", s.tls)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, implicitTLS)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) Close() {
+	s.listener.Close()
+}
+
+//configuration to send mails through the server
+func (s *fakeSMTP) configuration() SMTPConfiguration {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return SMTPConfiguration{
+		Server:   "127.0.0.1",
+		Port:     p,
+		TLS:      SMTPNoTLS,
+		Insecure: true,
+		From:     mail.Address{Name: "GoGo Build", Address: "gogobuild@example.com"},
+	}
+}
+
+func (s *fakeSMTP) received() []fakeMail {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]fakeMail(nil), s.mails...)
+}
+
+func (s *fakeSMTP) serve(conn net.Conn, secure bool) {
+	defer func() { conn.Close() }()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	readLine := func() (string, bool) {
+		line, err := reader.ReadString('\n')
+		return strings.TrimRight(line, "\r\n"), err == nil
+	}
+
+	var current fakeMail
+	authed := false
+	reply("220 fake ESMTP")
+	for {
+		line, ok := readLine()
+		if !ok {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO", "HELO":
+			extensions := []string{"250-fake"}
+			if s.startTLS && !secure {
+				extensions = append(extensions, "250-STARTTLS")
+			}
+			if len(s.username) > 0 {
+				extensions = append(extensions, "250-AUTH PLAIN LOGIN")
+			}
+			extensions = append(extensions, "250 8BITMIME")
+			reply(strings.Join(extensions, "\r\n"))
+		case "STARTTLS":
+			reply("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tls)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, reader, secure = tlsConn, bufio.NewReader(tlsConn), true
+		case "AUTH":
+			args := strings.Fields(line)
+			var username, password string
+			if len(args) >= 2 && strings.ToUpper(args[1]) == "PLAIN" {
+				encoded := ""
+				if len(args) == 3 {
+					encoded = args[2]
+				} else {
+					reply("334 ")
+					encoded, _ = readLine()
+				}
+				decoded, _ := base64.StdEncoding.DecodeString(encoded)
+				parts := strings.Split(string(decoded), "\x00")
+				if len(parts) == 3 {
+					username, password = parts[1], parts[2]
+				}
+			} else if len(args) >= 2 && strings.ToUpper(args[1]) == "LOGIN" {
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Username:")))
+				encoded, _ := readLine()
+				decoded, _ := base64.StdEncoding.DecodeString(encoded)
+				username = string(decoded)
+				reply("334 " + base64.StdEncoding.EncodeToString([]byte("Password:")))
+				encoded, _ = readLine()
+				decoded, _ = base64.StdEncoding.DecodeString(encoded)
+				password = string(decoded)
+			}
+			if username == s.username && password == s.password {
+				authed = true
+				reply("235 Authentication successful")
+			} else {
+				reply("535 Authentication credentials invalid")
+			}
+		case "MAIL":
+			if len(s.username) > 0 && !authed {
+				reply("530 Authentication required")
+				continue
+			}
+			current = fakeMail{From: envelopeAddress(line), TLS: secure, Authed: authed}
+			reply("250 OK")
+		case "RCPT":
+			current.To = append(current.To, envelopeAddress(line))
+			reply("250 OK")
+		case "DATA":
+			reply("354 Go ahead")
+			var data []string
+			for {
+				line, ok := readLine()
+				if !ok {
+					return
+				}
+				if line == "." {
+					break
+				}
+				data = append(data, line)
+			}
+			current.Data = strings.Join(data, "\n")
+			s.mutex.Lock()
+			s.mails = append(s.mails, current)
+			s.mutex.Unlock()
+			reply("250 Queued")
+		case "RSET", "NOOP":
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Unknown command")
+		}
+	}
+}
+
+//envelopeAddress return the <address> of a MAIL FROM or RCPT TO command
+func envelopeAddress(line string) string {
+	start := strings.Index(line, "<")
+	end := strings.Index(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
+
+var testRecipients = []mail.Address{{Name: "Ring Team", Address: "ring@example.com"}, {Address: "ops@example.com"}}
+
+func TestSendMailPlain(t *testing.T) {
+	server := newFakeSMTP(t, false, false, "", "")
+	defer server.Close()
+
+	if err := sendMail(server.configuration(), testRecipients, "Build failed", "<b>failed</b>"); err != nil {
+		t.Fatal(err)
+	}
+	mails := server.received()
+	if len(mails) != 1 {
+		t.Fatalf("%d mails received, expected 1", len(mails))
+	}
+	if mails[0].From != "gogobuild@example.com" || len(mails[0].To) != 2 || mails[0].To[1] != "ops@example.com" {
+		t.Errorf("unexpected envelope %+v", mails[0])
+	}
+	if !strings.Contains(mails[0].Data, "Subject: Build failed") ||
+		!strings.Contains(mails[0].Data, base64.StdEncoding.EncodeToString([]byte("<b>failed</b>"))) {
+		t.Errorf("unexpected message %s", mails[0].Data)
+	}
+}
+
+func TestSendMailStartTLSAuth(t *testing.T) {
+	server := newFakeSMTP(t, false, true, "gogo", "secret")
+	defer server.Close()
+
+	for _, auth := range []string{"plain", "login"} {
+		conf := server.configuration()
+		conf.TLS = SMTPStartTLS
+		conf.Auth = auth
+		conf.Username = "gogo"
+		conf.Password = "secret"
+		if err := sendMail(conf, testRecipients, "Test", "test"); err != nil {
+			t.Fatalf("%s: %s", auth, err)
+		}
+	}
+	for _, received := range server.received() {
+		if !received.TLS || !received.Authed {
+			t.Errorf("mail sent without TLS or auth: %+v", received)
+		}
+	}
+}
+
+func TestSendMailAuthError(t *testing.T) {
+	server := newFakeSMTP(t, false, true, "gogo", "secret")
+	defer server.Close()
+
+	conf := server.configuration()
+	conf.Auth = "plain"
+	conf.Username = "gogo"
+	conf.Password = "wrong"
+	err := sendMail(conf, testRecipients, "Test", "test")
+	if err == nil || !strings.Contains(err.Error(), "535") {
+		t.Errorf("got %v, expected the 535 SMTP error", err)
+	}
+	if len(server.received()) != 0 {
+		t.Error("mail sent with wrong credentials")
+	}
+}
+
+func TestSendMailStartTLSRequired(t *testing.T) {
+	server := newFakeSMTP(t, false, false, "", "")
+	defer server.Close()
+
+	conf := server.configuration()
+	conf.TLS = SMTPStartTLS
+	if err := sendMail(conf, testRecipients, "Test", "test"); err == nil {
+		t.Error("mail sent on a server without STARTTLS")
+	}
+}
+
+func TestSendMailImplicitTLS(t *testing.T) {
+	server := newFakeSMTP(t, true, false, "gogo", "secret")
+	defer server.Close()
+
+	conf := server.configuration()
+	conf.TLS = SMTPTLS
+	conf.Username = "gogo"
+	conf.Password = "secret"
+	conf.Auth = "plain"
+	if err := sendMail(conf, testRecipients[:1], "Test", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if mails := server.received(); len(mails) != 1 || !mails[0].TLS {
+		t.Errorf("got %+v, expected one mail over TLS", mails)
+	}
+}
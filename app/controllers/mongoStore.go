@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//MongoStore is the MongoDB BuildStore
+type MongoStore struct {
+	session *mgo.Session
+}
+
+//Init dial the MongoDB server
+func (m *MongoStore) Init(url string) error {
+	var err error
+	m.session, err = mgo.Dial(url)
+	return err
+}
+
+func (m *MongoStore) builds() *mgo.Collection {
+	return m.session.DB("gogobuild").C("builds")
+}
+
+func (m *MongoStore) deployments() *mgo.Collection {
+	return m.session.DB("gogobuild").C("deployments")
+}
+
+//SaveBuild insert or replace a build
+func (m *MongoStore) SaveBuild(build *Build) error {
+	_, err := m.builds().UpsertId(build.ID, build)
+	return err
+}
+
+//UpdateBuild update the state, timings, steps, artifacts, queue order and flags of a build
+func (m *MongoStore) UpdateBuild(build *Build) error {
+	return m.builds().Update(bson.M{"_id": build.ID},
+		bson.M{"$set": bson.M{"state": build.State, "lastupdated": time.Now(), "updateworkerduration": build.UpdateWorkerDuration, "startdate": build.StartDate,
+			"priority": build.Priority, "queueposition": build.QueuePosition, "steps": build.Steps,
+			"artifacts": build.Artifacts, "deployed": build.Deployed, "pruned": build.Pruned}})
+}
+
+//GetBuildByID return a build by it's id
+func (m *MongoStore) GetBuildByID(id string) (*Build, error) {
+	var build = new(Build)
+	if !bson.IsObjectIdHex(id) {
+		return build, ErrBuildNotFound
+	}
+	err := m.builds().FindId(bson.ObjectIdHex(id)).One(build)
+	if err == mgo.ErrNotFound {
+		err = ErrBuildNotFound
+	}
+	return build, err
+}
+
+//GetBuildsByProject return the builds of a project, most recent first
+func (m *MongoStore) GetBuildsByProject(projectName string) ([]Build, error) {
+	var buildList []Build
+	err := m.builds().Find(bson.M{"projecttobuild.name": projectName}).Sort("-date").All(&buildList)
+	return buildList, err
+}
+
+//GetUnfinishedBuilds return the builds not in a final state
+func (m *MongoStore) GetUnfinishedBuilds() ([]Build, error) {
+	var buildList []Build
+	err := m.builds().Find(bson.M{"state": bson.M{"$in": []State{Created, Init, Building}}}).All(&buildList)
+	return buildList, err
+}
+
+//SaveDeployment insert or replace a deployment
+func (m *MongoStore) SaveDeployment(deployment *Deployment) error {
+	_, err := m.deployments().UpsertId(deployment.ID, deployment)
+	return err
+}
+
+//GetDeploymentsByProject return the deployments of a project, most recent first
+func (m *MongoStore) GetDeploymentsByProject(projectName string) ([]Deployment, error) {
+	var deployments []Deployment
+	err := m.deployments().Find(bson.M{"project": projectName}).Sort("-startdate").All(&deployments)
+	return deployments, err
+}
+
+//GetUnfinishedDeployments return the deployments still running
+func (m *MongoStore) GetUnfinishedDeployments() ([]Deployment, error) {
+	var deployments []Deployment
+	err := m.deployments().Find(bson.M{"state": Building}).All(&deployments)
+	return deployments, err
+}
+
@@ -0,0 +1,287 @@
+package controllers
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	textTemplate "text/template"
+
+	"github.com/revel/revel"
+)
+
+//Notification events
+const (
+	FailureEvent  = "failure"
+	RecoveryEvent = "recovery"
+	SuccessEvent  = "success"
+	DeployEvent   = "deploy"
+)
+
+//Notification is a message about a build
+//ID identify what it is about: the build, or the deployment for the deploy event
+type Notification struct {
+	ID      string
+	Event   string
+	Subject string
+	Body    string
+	Build   *Build
+}
+
+//Notifier interface
+//A Notifier deliver notifications on one channel (email, webhook, Slack, Matrix)
+type Notifier interface {
+	Notify(n *Notification) error
+}
+
+//NotificationRule of .packer.json, who is notified of which events
+//Type is email (To are the addresses), owner (mail to the author of the review), webhook,
+//slack (URL is the hook) or matrix (URL is the homeserver, Room the room id).
+//Sys and Kinds (master, review, update, scheduled) limit the rule to some builds.
+//Template is a Go template file of the project, {{define "subject"}} set the subject.
+type NotificationRule struct {
+	Events   []string
+	Sys      []string
+	Kinds    []string
+	Type     string
+	To       []string
+	URL      string
+	Room     string
+	Template string
+}
+
+//NotificationData is given to the templates
+type NotificationData struct {
+	Event string
+	Build *Build
+	URL   string
+	Error string
+}
+
+//matches return true if the rule is about the event and build, and the first event it matches
+func (r *NotificationRule) matches(events []string, build *Build) (string, bool) {
+	if len(r.Sys) > 0 && !containsString(r.Sys, build.TargetSys) {
+		return "", false
+	}
+	kind := build.Kind()
+	if len(r.Kinds) > 0 && !containsString(r.Kinds, kind) {
+		return "", false
+	}
+	//Only the reviews have an owner
+	if r.Type == "owner" && kind != ReviewKind {
+		return "", false
+	}
+	for _, event := range events {
+		if containsString(r.Events, event) {
+			return event, true
+		}
+	}
+	return "", false
+}
+
+//isEmail return true if the rule send HTML mails
+func (r *NotificationRule) isEmail() bool {
+	return r.Type == "email" || r.Type == "owner"
+}
+
+//NotificationManager singleton, send the notifications of the builds following the project rules
+type NotificationManager struct {
+}
+
+//instance of NotificationManager
+var nmInstance *NotificationManager
+
+//NMInstance return the instance
+func NMInstance() *NotificationManager {
+	if nmInstance == nil {
+		nmInstance = new(NotificationManager)
+	}
+	return nmInstance
+}
+
+//NotifyBuild notify the end of a build, a success following a failure is a recovery
+func (m *NotificationManager) NotifyBuild(build *Build) {
+	var events []string
+	switch {
+	case build.State == Fail || build.State == TimedOut:
+		events = []string{FailureEvent}
+	case build.State.IsSuccess():
+		//Most specific first, a rule get only one notification
+		builds, _ := BMInstance().GetBuildsByProjects(build.ProjectToBuild.Name)
+		if previous := previousBuild(builds, build); previous != nil && (previous.State == Fail || previous.State == TimedOut) {
+			events = append(events, RecoveryEvent)
+		}
+		events = append(events, SuccessEvent)
+	default:
+		return
+	}
+	m.notify(build.ID.Hex(), build, events, "")
+}
+
+//NotifyDeploy notify a deployment of a build, err is the deployment error if any
+func (m *NotificationManager) NotifyDeploy(build *Build, deployment *Deployment, err error) {
+	message := ""
+	if err != nil {
+		message = err.Error()
+	}
+	m.notify(deployment.ID.Hex(), build, []string{DeployEvent}, message)
+}
+
+//previousBuild return the last finished build of the same ref and sys before build
+//builds are the builds of the project, most recent first
+func previousBuild(builds []Build, build *Build) *Build {
+	for i := range builds {
+		previous := &builds[i]
+		if previous.ID == build.ID || !previous.Date.Before(build.Date) {
+			continue
+		}
+		if previous.TargetSys == build.TargetSys && previous.Commit == build.Commit && !previous.State.IsRunning() && previous.State != Cancelled {
+			return previous
+		}
+	}
+	return nil
+}
+
+func (m *NotificationManager) notify(id string, build *Build, events []string, message string) {
+	for _, rule := range m.rules(build) {
+		event, ok := rule.matches(events, build)
+		if !ok {
+			continue
+		}
+		notifier, err := newNotifier(rule, build)
+		if err != nil {
+			revel.WARN.Println(err)
+			continue
+		}
+		data := NotificationData{Event: event, Build: build, URL: build.URL(), Error: message}
+		notification, err := m.render(build.ProjectToBuild.Name, rule, data)
+		if err != nil {
+			revel.WARN.Println(err)
+			continue
+		}
+		notification.ID = id
+		if err := notifier.Notify(notification); err != nil {
+			revel.WARN.Printf("%s notification of build %s failed: %s", rule.Type, build.ID.Hex(), err)
+		}
+	}
+}
+
+//rules of the build project
+//Without Notifications, failed reviews are mailed to their owner when the project has a review
+//system, and the other failed builds to NotificationMailAdress ([name, address])
+func (m *NotificationManager) rules(build *Build) []NotificationRule {
+	conf := build.ProjectToBuild.Configuration
+	if len(conf.Notifications) > 0 {
+		return conf.Notifications
+	}
+	return defaultRules(conf, PMInstance().GetProjectByName(build.ProjectToBuild.Name).ReviewManagerInstance != nil)
+}
+
+//defaultRules are the rules of a project without Notifications
+func defaultRules(conf ProjectConfiguration, hasReview bool) []NotificationRule {
+	var rules []NotificationRule
+	if len(conf.NotificationMailAdress) >= 2 {
+		rules = append(rules, NotificationRule{
+			Events: []string{FailureEvent},
+			Kinds:  []string{MasterKind, UpdateKind, ScheduledKind},
+			Type:   "email",
+			To:     []string{fmt.Sprintf("%s <%s>", conf.NotificationMailAdress[0], conf.NotificationMailAdress[1])},
+		})
+	}
+	if hasReview {
+		rules = append(rules, NotificationRule{Events: []string{FailureEvent}, Type: "owner"})
+	}
+	return rules
+}
+
+//render the subject and body of a notification with the rule template or the default one
+//Email bodies are HTML, the other are text
+func (m *NotificationManager) render(projectName string, rule NotificationRule, data NotificationData) (*Notification, error) {
+	source := defaultNotificationTemplate
+	if rule.isEmail() {
+		source = defaultMailTemplate
+	}
+	if len(rule.Template) > 0 {
+		content, err := ioutil.ReadFile(filepath.Join(revel.BasePath, "public/projects", projectName, rule.Template))
+		if err != nil {
+			return nil, err
+		}
+		source = string(content)
+	}
+	subject, body, err := renderNotification(source, rule.isEmail(), data)
+	if err != nil {
+		return nil, fmt.Errorf("Template %s of %s: %s", rule.Template, projectName, err)
+	}
+	return &Notification{Event: data.Event, Subject: subject, Body: body, Build: data.Build}, nil
+}
+
+//executor is the part of html/template and text/template used to render the notifications
+type executor interface {
+	ExecuteTemplate(w io.Writer, name string, data interface{}) error
+	Execute(w io.Writer, data interface{}) error
+}
+
+//renderNotification execute a notification template, the subject is its "subject" template
+func renderNotification(source string, html bool, data NotificationData) (string, string, error) {
+	var tmpl executor
+	var hasSubject bool
+	if html {
+		t, err := template.New("notification").Parse(source)
+		if err != nil {
+			return "", "", err
+		}
+		tmpl, hasSubject = t, t.Lookup("subject") != nil
+	} else {
+		t, err := textTemplate.New("notification").Parse(source)
+		if err != nil {
+			return "", "", err
+		}
+		tmpl, hasSubject = t, t.Lookup("subject") != nil
+	}
+
+	subject := fmt.Sprintf("GoGo Build %s/%s: %s", data.Build.ProjectToBuild.Name, data.Build.TargetSys, data.Event)
+	if hasSubject {
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, "subject", data); err != nil {
+			return "", "", err
+		}
+		subject = strings.TrimSpace(buf.String())
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return "", "", err
+	}
+	return subject, strings.TrimSpace(body.String()), nil
+}
+
+//defaultSubject is the subject of the default templates
+const defaultSubject = `{{define "subject"}}GoGo Build {{.Build.ProjectToBuild.Name}}/{{.Build.TargetSys}} {{if eq .Event "failure"}}Failed{{else if eq .Event "recovery"}}Fixed{{else if eq .Event "deploy"}}{{if .Error}}Deploy Failed{{else}}Deployed{{end}}{{else}}Succeeded{{end}}{{end}}`
+
+//defaultMailTemplate is used by the email rules without Template
+const defaultMailTemplate = defaultSubject + `
+Build <a href="{{.URL}}">{{.Build.ID.Hex}}</a> of project {{.Build.ProjectToBuild.Name}} ({{.Build.Commit}}) for sys {{.Build.TargetSys}}
+{{if eq .Event "failure"}}has failed ({{.Build.State}}).
+{{else if eq .Event "recovery"}}is fixed.
+{{else if eq .Event "deploy"}}{{if .Error}}failed to deploy: {{.Error}}{{else}}is deployed ({{.Build.ReleaseString}}).{{end}}
+{{else}}succeeded ({{.Build.State}}).
+{{end}}`
+
+//defaultNotificationTemplate is used by the other rules without Template
+const defaultNotificationTemplate = defaultSubject + `
+{{if eq .Event "failure"}}Build {{.URL}} of project {{.Build.ProjectToBuild.Name}} ({{.Build.Commit}}) for sys {{.Build.TargetSys}} has failed ({{.Build.State}}).
+{{else if eq .Event "recovery"}}Build {{.URL}} of project {{.Build.ProjectToBuild.Name}} ({{.Build.Commit}}) for sys {{.Build.TargetSys}} is fixed.
+{{else if eq .Event "deploy"}}Build {{.URL}} of project {{.Build.ProjectToBuild.Name}} for sys {{.Build.TargetSys}} {{if .Error}}failed to deploy: {{.Error}}{{else}}is deployed ({{.Build.ReleaseString}}).{{end}}
+{{else}}Build {{.URL}} of project {{.Build.ProjectToBuild.Name}} ({{.Build.Commit}}) for sys {{.Build.TargetSys}} succeeded ({{.Build.State}}).
+{{end}}`
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
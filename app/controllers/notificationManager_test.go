@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func newNotificationBuild(commit string, state State) *Build {
+	build := &Build{ID: bson.NewObjectId(), TargetSys: "win32", Commit: commit, State: state, Priority: MasterPriority}
+	build.ProjectToBuild.Name = "ring"
+	return build
+}
+
+func TestRenderNotification(t *testing.T) {
+	build := newNotificationBuild("refs/pull/7/<head>", Fail)
+	tests := []struct {
+		name    string
+		source  string
+		html    bool
+		event   string
+		subject string
+		body    []string
+	}{
+		{"default mail", defaultMailTemplate, true, FailureEvent,
+			"GoGo Build ring/win32 Failed", []string{"has failed (Fail)", "refs/pull/7/&lt;head&gt;", `<a href="`}},
+		{"default text", defaultNotificationTemplate, false, RecoveryEvent,
+			"GoGo Build ring/win32 Fixed", []string{"refs/pull/7/<head>", "is fixed."}},
+		{"custom subject", `{{define "subject"}} {{.Event}} of {{.Build.TargetSys}} {{end}}{{.Build.Commit}}`, false, SuccessEvent,
+			"success of win32", []string{"refs/pull/7/<head>"}},
+		{"default subject", `{{.Event}}`, true, DeployEvent,
+			"GoGo Build ring/win32: deploy", []string{"deploy"}},
+	}
+	for _, test := range tests {
+		subject, body, err := renderNotification(test.source, test.html, NotificationData{Event: test.event, Build: build})
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if subject != test.subject {
+			t.Errorf("%s: got subject %q, expected %q", test.name, subject, test.subject)
+		}
+		for _, expected := range test.body {
+			if !strings.Contains(body, expected) {
+				t.Errorf("%s: %q is not in %q", test.name, expected, body)
+			}
+		}
+	}
+
+	if _, _, err := renderNotification("{{.Event", false, NotificationData{Build: build}); err == nil {
+		t.Error("expected a template error")
+	}
+	if _, _, err := renderNotification("{{.Unknown}}", false, NotificationData{Build: build}); err == nil {
+		t.Error("expected an execution error")
+	}
+}
+
+func TestNotificationRuleMatches(t *testing.T) {
+	master := newNotificationBuild("master", Fail)
+	review := newNotificationBuild("refs/changes/45/12345/3", Fail)
+	review.Priority = ReviewPriority
+	update := newNotificationBuild("updateWorker", Fail)
+	scheduled := newNotificationBuild("master", Fail)
+	scheduled.Scheduled = true
+	prioritized := newNotificationBuild("master", Fail)
+	prioritized.Priority = ScheduledPriority
+
+	failure := []string{FailureEvent}
+	recovery := []string{RecoveryEvent, SuccessEvent}
+	tests := []struct {
+		name   string
+		rule   NotificationRule
+		events []string
+		build  *Build
+		event  string
+		match  bool
+	}{
+		{"event", NotificationRule{Events: []string{FailureEvent}}, failure, master, FailureEvent, true},
+		{"other event", NotificationRule{Events: []string{SuccessEvent}}, failure, master, "", false},
+		{"most specific event first", NotificationRule{Events: []string{SuccessEvent, RecoveryEvent}}, recovery, master, RecoveryEvent, true},
+		{"success without recovery", NotificationRule{Events: []string{SuccessEvent}}, recovery, master, SuccessEvent, true},
+		{"sys", NotificationRule{Events: []string{FailureEvent}, Sys: []string{"win32"}}, failure, master, FailureEvent, true},
+		{"other sys", NotificationRule{Events: []string{FailureEvent}, Sys: []string{"linux"}}, failure, master, "", false},
+		{"kind", NotificationRule{Events: []string{FailureEvent}, Kinds: []string{ReviewKind}}, failure, review, FailureEvent, true},
+		{"other kind", NotificationRule{Events: []string{FailureEvent}, Kinds: []string{ReviewKind}}, failure, master, "", false},
+		{"update kind", NotificationRule{Events: []string{FailureEvent}, Kinds: []string{UpdateKind}}, failure, update, FailureEvent, true},
+		{"scheduled kind", NotificationRule{Events: []string{FailureEvent}, Kinds: []string{ScheduledKind}}, failure, scheduled, FailureEvent, true},
+		{"scheduled is not master", NotificationRule{Events: []string{FailureEvent}, Kinds: []string{MasterKind}}, failure, scheduled, "", false},
+		{"kind is not the priority", NotificationRule{Events: []string{FailureEvent}, Kinds: []string{MasterKind}}, failure, prioritized, FailureEvent, true},
+		{"owner of a review", NotificationRule{Events: []string{FailureEvent}, Type: "owner"}, failure, review, FailureEvent, true},
+		{"no owner for master", NotificationRule{Events: []string{FailureEvent}, Type: "owner"}, failure, master, "", false},
+	}
+	for _, test := range tests {
+		event, match := test.rule.matches(test.events, test.build)
+		if event != test.event || match != test.match {
+			t.Errorf("%s: got %q, %v, expected %q, %v", test.name, event, match, test.event, test.match)
+		}
+	}
+}
+
+func TestPreviousBuild(t *testing.T) {
+	now := time.Now()
+	build := newNotificationBuild("master", Success)
+	build.Date = now
+	at := func(b *Build, age time.Duration) Build {
+		b.Date = now.Add(-age)
+		return *b
+	}
+	linux := newNotificationBuild("master", Fail)
+	linux.TargetSys = "linux"
+	builds := []Build{
+		at(newNotificationBuild("master", Building), -time.Minute),
+		*build,
+		at(newNotificationBuild("master", Building), time.Minute),
+		at(newNotificationBuild("master", Cancelled), 2*time.Minute),
+		at(linux, 3*time.Minute),
+		at(newNotificationBuild("refs/pull/7/head", Success), 4*time.Minute),
+		at(newNotificationBuild("master", Fail), 5*time.Minute),
+		at(newNotificationBuild("master", Success), 6*time.Minute),
+	}
+	previous := previousBuild(builds, build)
+	if previous == nil || previous.ID != builds[6].ID {
+		t.Errorf("got %v, expected the failed master build", previous)
+	}
+	if previous := previousBuild(builds[:6], build); previous != nil {
+		t.Errorf("got %v, expected no previous build", previous)
+	}
+}
+
+func TestDefaultRules(t *testing.T) {
+	conf := ProjectConfiguration{NotificationMailAdress: []string{"Ring", "ring@example.com"}}
+	master := newNotificationBuild("master", Fail)
+	review := newNotificationBuild("refs/pull/7/head", Fail)
+	review.Priority = ReviewPriority
+
+	tests := []struct {
+		name      string
+		conf      ProjectConfiguration
+		hasReview bool
+		build     *Build
+		types     []string
+	}{
+		{"master to the project address", conf, true, master, []string{"email"}},
+		{"review to its owner", conf, true, review, []string{"owner"}},
+		{"review without review system", conf, false, review, nil},
+		{"no address", ProjectConfiguration{}, false, master, nil},
+	}
+	for _, test := range tests {
+		var types []string
+		for _, rule := range defaultRules(test.conf, test.hasReview) {
+			if _, ok := rule.matches([]string{FailureEvent}, test.build); ok {
+				types = append(types, rule.Type)
+			}
+		}
+		if strings.Join(types, ",") != strings.Join(test.types, ",") {
+			t.Errorf("%s: got %v, expected %v", test.name, types, test.types)
+		}
+	}
+	rules := defaultRules(conf, false)
+	if len(rules) != 1 || rules[0].To[0] != "Ring <ring@example.com>" {
+		t.Errorf("unexpected rules %v", rules)
+	}
+	if _, ok := rules[0].matches([]string{SuccessEvent}, master); ok {
+		t.Error("only failures are notified by default")
+	}
+}
+
+func TestMatrixTxn(t *testing.T) {
+	build := newNotificationBuild("master", Success)
+	first, second := newDeployment(build, "auto"), newDeployment(build, "alice")
+	txns := map[string]bool{}
+	for _, n := range []*Notification{
+		{ID: build.ID.Hex(), Event: SuccessEvent, Build: build},
+		{ID: first.ID.Hex(), Event: DeployEvent, Build: build},
+		{ID: second.ID.Hex(), Event: DeployEvent, Build: build},
+	} {
+		txns[matrixTxn(n)] = true
+	}
+	if len(txns) != 3 {
+		t.Errorf("got %v, expected a transaction per build event and deployment", txns)
+	}
+	resent := &Notification{ID: first.ID.Hex(), Event: DeployEvent, Build: build}
+	if !txns[matrixTxn(resent)] {
+		t.Error("a resent notification must reuse its transaction id")
+	}
+}
@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/revel/revel"
+)
+
+//notifierTimeout bound the requests of the webhook, Slack and Matrix notifiers
+const notifierTimeout = 30 * time.Second
+
+//newNotifier return the Notifier of a rule for build
+func newNotifier(rule NotificationRule, build *Build) (Notifier, error) {
+	switch rule.Type {
+	case "email":
+		var to []mail.Address
+		for _, recipient := range rule.To {
+			address, err := mail.ParseAddress(recipient)
+			if err != nil {
+				return nil, fmt.Errorf("Notification recipient %s: %s", recipient, err)
+			}
+			to = append(to, *address)
+		}
+		return &EmailNotifier{To: to}, nil
+	case "owner":
+		reviewManager := PMInstance().GetProjectByName(build.ProjectToBuild.Name).ReviewManagerInstance
+		if reviewManager == nil {
+			return nil, fmt.Errorf("%s has no review system to find the owner of %s", build.ProjectToBuild.Name, build.Commit)
+		}
+		owner, err := reviewManager.GetOwner(build.Commit)
+		if err != nil {
+			return nil, fmt.Errorf("Owner of %s: %s", build.Commit, err)
+		}
+		if owner == nil {
+			return nil, fmt.Errorf("%s is not a review", build.Commit)
+		}
+		return &EmailNotifier{To: []mail.Address{*owner}}, nil
+	case "webhook":
+		return &WebhookNotifier{URL: rule.URL}, nil
+	case "slack":
+		return &SlackNotifier{URL: rule.URL}, nil
+	case "matrix":
+		return &MatrixNotifier{
+			URL:   rule.URL,
+			Room:  rule.Room,
+			Token: revel.Config.StringDefault("matrix.token", ""),
+		}, nil
+	}
+	return nil, fmt.Errorf("Not a valid notification type %s", rule.Type)
+}
+
+//EmailNotifier send the notifications by mail
+type EmailNotifier struct {
+	To []mail.Address
+}
+
+//Notify by mail
+func (e *EmailNotifier) Notify(n *Notification) error {
+	return MMInstance().Send(e.To, n.Subject, n.Body)
+}
+
+//WebhookPayload is the JSON posted by the WebhookNotifier
+type WebhookPayload struct {
+	Event   string
+	Subject string
+	Body    string
+	Build   *Build
+}
+
+//WebhookNotifier post the notifications as JSON to an URL
+type WebhookNotifier struct {
+	URL string
+}
+
+//Notify by webhook
+func (w *WebhookNotifier) Notify(n *Notification) error {
+	return sendJSON("POST", w.URL, "", WebhookPayload{Event: n.Event, Subject: n.Subject, Body: n.Body, Build: n.Build})
+}
+
+//SlackNotifier post the notifications to a Slack compatible incoming webhook
+type SlackNotifier struct {
+	URL string
+}
+
+//Notify on Slack
+func (s *SlackNotifier) Notify(n *Notification) error {
+	return sendJSON("POST", s.URL, "", map[string]string{"text": n.Subject + "\n" + n.Body})
+}
+
+//MatrixNotifier send the notifications as messages of a Matrix room
+type MatrixNotifier struct {
+	URL   string
+	Room  string
+	Token string
+}
+
+//Notify on Matrix
+func (m *MatrixNotifier) Notify(n *Notification) error {
+	if len(m.Token) == 0 {
+		return errors.New("No matrix.token configured in app.conf")
+	}
+	endpoint := fmt.Sprintf("%s/_matrix/client/r0/rooms/%s/send/m.room.message/%s",
+		strings.TrimSuffix(m.URL, "/"), url.PathEscape(m.Room), matrixTxn(n))
+	return sendJSON("PUT", endpoint, m.Token, map[string]string{
+		"msgtype": "m.text",
+		"body":    n.Subject + "\n" + n.Body,
+	})
+}
+
+//matrixTxn return the transaction id of a notification, it make the request idempotent:
+//a resent notification is not posted twice, each deployment of a build is posted
+func matrixTxn(n *Notification) string {
+	return fmt.Sprintf("gogobuild-%s-%s", n.ID, n.Event)
+}
+
+//sendJSON send a JSON payload, token is used as Bearer authorization if set
+func sendJSON(method string, endpoint string, token string, payload interface{}) error {
+	if len(endpoint) == 0 {
+		return errors.New("No URL to notify")
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: notifierTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s %s", method, endpoint, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -20,6 +20,16 @@ func (pc ProjectsController) Index() revel.Result {
 	return pc.Render(projectsList)
 }
 
+//Retention report the builds pruned by the retention rules of the project
+//Nothing is deleted, the rules are applied by the scheduled RetentionJob
+func (pc ProjectsController) Retention() revel.Result {
+	report, err := BMInstance().PruneBuilds(pc.Params.Get("project"), true)
+	if err != nil {
+		return pc.RenderError(err)
+	}
+	return pc.RenderJson(report)
+}
+
 //Build a project
 func (pc ProjectsController) Build() revel.Result {
 	var deploy bool
@@ -28,7 +38,7 @@ func (pc ProjectsController) Build() revel.Result {
 	} else {
 		deploy = len(pc.Params.Get("submitDeploy")) > 0
 	}
-	build, _ := BMInstance().CreateOrReturnStatusBuild(pc.Params.Get("project"), pc.Params.Get("sys"), pc.Params.Get("commit"), deploy)
+	build, _ := BMInstance().CreateOrReturnStatusBuild(pc.Params.Get("project"), pc.Params.Get("sys"), pc.Params.Get("commit"), deploy, ManualPriority)
 	if build.State == Created {
 		pc.Flash.Success("Build Started %s %s", pc.Params.Get("project"), pc.Params.Get("sys"))
 	} else if build.State == Fail {
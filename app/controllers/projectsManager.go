@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/mail"
 	"os"
 	"os/exec"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/fsouza/go-dockerclient"
 	"github.com/revel/modules/jobs/app/jobs"
 	"github.com/revel/revel"
 )
@@ -18,19 +22,90 @@ type ProjectConfiguration struct {
 	BuildType              string
 	BuildInstructions      map[string][]string
 	UpdateInstructions     map[string][]string
+	Env                    map[string]map[string]string
+	BuildArgs              map[string]map[string]string
+	HostLabels             map[string][]string
+	BuildTimeout           map[string]string
+	UpdateTimeout          map[string]string
 	ReviewType             string
 	ReviewAddress          string
+	ReviewRepository       string
+	ReviewLabels           map[string]map[string]int
+	ReviewWatchSchedule    string
 	Package                map[string]string
+	Artifacts              map[string][]ArtifactPattern
+	Retention              RetentionConfiguration
 	ReloadProjectCmd       []string
 	AutoDeploySchedule     map[string]string
+	Hooks                  HooksConfiguration
 	DeployScript           string
+	DeployTargets          map[string]DeployTarget
 	NotificationMailAdress []string
+	Notifications          []NotificationRule
+}
+
+//GetDeployTarget return where the builds of sys are deployed
+//Without DeployTargets for sys, the DeployScript is run
+func (c *ProjectConfiguration) GetDeployTarget(sys string) (DeployTarget, error) {
+	if target, ok := c.DeployTargets[sys]; ok {
+		return target, nil
+	}
+	if len(c.DeployScript) > 0 {
+		return DeployTarget{Type: "script", Script: c.DeployScript}, nil
+	}
+	return DeployTarget{}, fmt.Errorf("No deploy target for %s", sys)
+}
+
+//GetBuildTimeout return the maximum duration of a build for sys, 0 if unlimited
+func (c *ProjectConfiguration) GetBuildTimeout(sys string) time.Duration {
+	return parseTimeout(c.BuildTimeout[sys])
+}
+
+//GetUpdateTimeout return the maximum duration of the builder image update for sys, 0 if unlimited
+func (c *ProjectConfiguration) GetUpdateTimeout(sys string) time.Duration {
+	return parseTimeout(c.UpdateTimeout[sys])
+}
+
+//GetBuildArgs return the build args of the builder image of sys, sorted by name
+func (c *ProjectConfiguration) GetBuildArgs(sys string) []docker.BuildArg {
+	var names []string
+	for name := range c.BuildArgs[sys] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var args []docker.BuildArg
+	for _, name := range names {
+		args = append(args, docker.BuildArg{Name: name, Value: c.BuildArgs[sys][name]})
+	}
+	return args
+}
+
+//parseTimeout parse a duration (e.g "2h30m") of .packer.json
+func parseTimeout(timeout string) time.Duration {
+	if len(timeout) == 0 {
+		return 0
+	}
+	duration, err := time.ParseDuration(timeout)
+	if err != nil {
+		revel.WARN.Printf("Invalid timeout %s: %s", timeout, err)
+		return 0
+	}
+	return duration
+}
+
+//Change is an open review, SHA is the commit of its current head
+type Change struct {
+	Ref string
+	SHA string
 }
 
 //ReviewManager interface
+//GetOwner return the address of the author of a review ref, nil if ref is not a review
 type ReviewManager interface {
 	Init(p *Project)
-	GetOpenChanges() ([]string, error)
+	GetOpenChanges() ([]Change, error)
+	ReportBuild(build *Build) error
+	GetOwner(ref string) (*mail.Address, error)
 }
 
 //Project struct
@@ -50,10 +125,16 @@ func (p *Project) Init(dir os.FileInfo) error {
 				//Explicitly capture sys
 				targetSys := sys
 				jobs.Schedule(time, jobs.Func(func() {
-					BMInstance().CreateOrReturnStatusBuild(p.Name, targetSys, "master", true)
+					BMInstance().CreateOrReturnStatusBuild(p.Name, targetSys, "master", true, ScheduledPriority)
 				}))
 			}
 		}
+		if len(p.Configuration.ReviewWatchSchedule) > 0 && p.ReviewManagerInstance != nil {
+			jobs.Schedule(p.Configuration.ReviewWatchSchedule, NewReviewWatcher(p.Name))
+		}
+		if p.Configuration.Retention.IsSet() {
+			jobs.Schedule(p.Configuration.Retention.GetSchedule(), NewRetentionJob(p.Name))
+		}
 	}
 	return err
 }
@@ -102,6 +183,15 @@ func (p *Project) loadConf(fileName string) error {
 	case "Gerrit":
 		p.ReviewManagerInstance = new(GerritManager)
 		p.ReviewManagerInstance.Init(p)
+	case "GitHub":
+		p.ReviewManagerInstance = new(GitHubManager)
+		p.ReviewManagerInstance.Init(p)
+	case "GitLab":
+		p.ReviewManagerInstance = new(GitLabManager)
+		p.ReviewManagerInstance.Init(p)
+	case "Gitea":
+		p.ReviewManagerInstance = new(GiteaManager)
+		p.ReviewManagerInstance.Init(p)
 	}
 	return nil
 }
@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"github.com/revel/revel"
+)
+
+//QueueController Controller
+type QueueController struct {
+	*revel.Controller
+}
+
+//Index page, running and queued builds
+func (c QueueController) Index() revel.Result {
+	running, err := BMInstance().GetRunningBuilds()
+	if err != nil {
+		c.Flash.Error(err.Error())
+	}
+	queue, err := BMInstance().GetQueue()
+	if err != nil {
+		c.Flash.Error(err.Error())
+	}
+	hosts := HPInstance().Hosts()
+	if c.Params.Get("format") == "json" {
+		return c.RenderJson(map[string]interface{}{"Running": running, "Queued": queue, "Hosts": hosts})
+	}
+	return c.Render(running, queue, hosts)
+}
+
+//Move a queued build up or down
+func (c QueueController) Move() revel.Result {
+	err := BMInstance().MoveQueuedBuild(c.Params.Get("id"), c.Params.Get("direction") == "up")
+	if err != nil {
+		c.Flash.Error(err.Error())
+	}
+	return c.Redirect(QueueController.Index)
+}
+
+//Drop a queued build
+func (c QueueController) Drop() revel.Result {
+	build, err := BMInstance().GetBuildByID(c.Params.Get("id"))
+	if err == nil {
+		err = BMInstance().CancelBuild(build)
+	}
+	if err != nil {
+		c.Flash.Error(err.Error())
+	}
+	return c.Redirect(QueueController.Index)
+}
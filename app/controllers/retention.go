@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/revel/revel"
+	"gopkg.in/mgo.v2/bson"
+)
+
+//RetentionConfiguration of .packer.json, which builds output are kept
+//A finished build is pruned when it is not one of the KeepLast most recent of its sys
+//or when it is older than MaxAge (e.g "720h"). Deployed builds are kept with KeepDeployed.
+//The latest Success and the latest FallbackSuccess master builds of each sys are always kept
+//for the /latest links (with and without fallback), and so is the build deployed live on each sys.
+type RetentionConfiguration struct {
+	KeepLast     int
+	KeepDeployed bool
+	MaxAge       string
+	Schedule     string
+}
+
+//IsSet return true if at least one rule prune builds
+func (r *RetentionConfiguration) IsSet() bool {
+	return r.KeepLast > 0 || len(r.MaxAge) > 0
+}
+
+//GetSchedule return when the retention job runs, daily by default
+func (r *RetentionConfiguration) GetSchedule() string {
+	if len(r.Schedule) == 0 {
+		return "@daily"
+	}
+	return r.Schedule
+}
+
+//PrunedBuild is a build removed (or to be removed) by the retention rules
+type PrunedBuild struct {
+	ID        string
+	TargetSys string
+	Commit    string
+	Date      time.Time
+	State     string
+	Reason    string
+}
+
+//RetentionReport list the builds pruned by a run of the retention rules
+type RetentionReport struct {
+	Project string
+	DryRun  bool
+	Kept    int
+	Pruned  []PrunedBuild
+	Errors  []string
+}
+
+//PruneBuilds apply the retention rules of a project
+//Output directories are deleted and the records marked as pruned, nothing is done with dryRun
+func (b *BuildManager) PruneBuilds(projectName string, dryRun bool) (RetentionReport, error) {
+	report := RetentionReport{Project: projectName, DryRun: dryRun, Pruned: []PrunedBuild{}}
+	rules := PMInstance().GetProjectByName(projectName).Configuration.Retention
+	if !rules.IsSet() {
+		return report, nil
+	}
+
+	//Most recent first
+	buildList, err := b.store.GetBuildsByProject(projectName)
+	if err != nil {
+		return report, err
+	}
+	deployments, err := b.store.GetDeploymentsByProject(projectName)
+	if err != nil {
+		return report, err
+	}
+	live := make(map[bson.ObjectId]bool)
+	for _, deployment := range liveDeployments(deployments) {
+		live[deployment.BuildID] = true
+	}
+
+	reasons := retentionReasons(rules, buildList, live, time.Now())
+	for i := range buildList {
+		build := &buildList[i]
+		if build.State.IsRunning() || build.Pruned {
+			continue
+		}
+		reason := reasons[i]
+		if len(reason) == 0 {
+			report.Kept++
+			continue
+		}
+
+		report.Pruned = append(report.Pruned, PrunedBuild{
+			ID:        build.ID.Hex(),
+			TargetSys: build.TargetSys,
+			Commit:    build.Commit,
+			Date:      build.Date,
+			State:     build.State.String(),
+			Reason:    reason,
+		})
+		if dryRun {
+			continue
+		}
+		if err := b.pruneBuild(build); err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", build.ID.Hex(), err))
+		}
+	}
+	return report, nil
+}
+
+//retentionReasons return why each of builds (most recent first) is pruned, empty when it is kept
+//live are the builds deployed live, they are kept like the latest master builds
+func retentionReasons(rules RetentionConfiguration, builds []Build, live map[bson.ObjectId]bool, now time.Time) []string {
+	maxAge := parseTimeout(rules.MaxAge)
+	reasons := make([]string, len(builds))
+	count := make(map[string]int)
+	latest := make(map[string]bool)
+	for i := range builds {
+		build := &builds[i]
+		if build.State.IsRunning() {
+			continue
+		}
+		count[build.TargetSys]++
+
+		reason := ""
+		if rules.KeepLast > 0 && count[build.TargetSys] > rules.KeepLast {
+			reason = fmt.Sprintf("not in the last %d builds", rules.KeepLast)
+		} else if maxAge > 0 && now.Sub(build.Date) > maxAge {
+			reason = fmt.Sprintf("older than %s", rules.MaxAge)
+		}
+		if latestKey := build.TargetSys + "/" + build.State.String(); build.Commit == "master" && build.State.IsSuccess() && !build.Pruned && !latest[latestKey] {
+			latest[latestKey] = true
+			reason = ""
+		}
+		if live[build.ID] || (rules.KeepDeployed && build.Deployed) {
+			reason = ""
+		}
+		reasons[i] = reason
+	}
+	return reasons
+}
+
+//pruneBuild delete the output of a build and mark it as pruned
+//Its deployment records are kept, they are the deployment history
+func (b *BuildManager) pruneBuild(build *Build) error {
+	output := filepath.Join(revel.BasePath, build.OutputPath())
+	if err := os.RemoveAll(output); err != nil {
+		return err
+	}
+	//Remove the date directory once all the sys are gone, fails if it is not empty
+	os.Remove(filepath.Dir(output))
+
+	build.Pruned = true
+	build.Artifacts = nil
+	return b.store.UpdateBuild(build)
+}
+
+//RetentionJob apply the retention rules of a project
+//It is scheduled with Retention.Schedule of .packer.json
+type RetentionJob struct {
+	projectName string
+}
+
+//NewRetentionJob return a retention job for the project
+func NewRetentionJob(projectName string) *RetentionJob {
+	return &RetentionJob{projectName: projectName}
+}
+
+//Run prune the builds
+func (j *RetentionJob) Run() {
+	report, err := BMInstance().PruneBuilds(j.projectName, false)
+	if err != nil {
+		revel.WARN.Println(err)
+		return
+	}
+	for _, e := range report.Errors {
+		revel.WARN.Printf("Pruning %s: %s", j.projectName, e)
+	}
+	if len(report.Pruned) > 0 {
+		revel.INFO.Printf("Pruned %d builds of %s", len(report.Pruned), j.projectName)
+	}
+}
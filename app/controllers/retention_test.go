@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//newRetentionBuilds return finished builds of sys, one per state, most recent first and an hour apart
+func newRetentionBuilds(now time.Time, sys string, commit string, states ...State) []Build {
+	var builds []Build
+	for i, state := range states {
+		builds = append(builds, Build{
+			ID:        bson.NewObjectId(),
+			Date:      now.Add(-time.Duration(i) * time.Hour),
+			TargetSys: sys,
+			Commit:    commit,
+			State:     state,
+		})
+	}
+	return builds
+}
+
+func TestRetentionReasonsLive(t *testing.T) {
+	now := time.Now()
+	builds := newRetentionBuilds(now, "win32", "master", Success, Success, Success, Success)
+	rules := RetentionConfiguration{KeepLast: 1}
+
+	//The third build was deployed, then the deployment of the second one failed
+	live := map[bson.ObjectId]bool{}
+	deployments := []Deployment{
+		{BuildID: builds[1].ID, TargetSys: "win32", State: Fail, StartDate: now},
+		{BuildID: builds[2].ID, TargetSys: "win32", State: Success, StartDate: now.Add(-time.Hour)},
+	}
+	for _, deployment := range liveDeployments(deployments) {
+		live[deployment.BuildID] = true
+	}
+	reasons := retentionReasons(rules, builds, live, now)
+	for i, pruned := range []bool{false, true, false, true} {
+		if (len(reasons[i]) > 0) != pruned {
+			t.Errorf("build %d: got reason %q, pruned expected: %v", i, reasons[i], pruned)
+		}
+	}
+}
+
+func TestRetentionReasons(t *testing.T) {
+	now := time.Now()
+	var builds []Build
+	//win32: the latest Success is the third build and the latest FallbackSuccess the fourth
+	builds = append(builds, newRetentionBuilds(now, "win32", "master", Fail, TimedOut, Success, FallbackSuccess, Success, FallbackSuccess)...)
+	//linux: the latest Success is already pruned, the next one is kept in its place
+	linux := newRetentionBuilds(now, "linux", "master", Success, Success, Building)
+	linux[0].Pruned = true
+	builds = append(builds, linux...)
+	//A review build is never the latest master build
+	builds = append(builds, newRetentionBuilds(now.Add(-time.Minute), "win32", "refs/pull/7/head", Success)...)
+	//A deployed build with KeepDeployed
+	osx := newRetentionBuilds(now, "osx", "master", Fail, Fail, Fail)
+	osx[2].Deployed = true
+	builds = append(builds, osx...)
+
+	rules := RetentionConfiguration{KeepLast: 1, KeepDeployed: true}
+	reasons := retentionReasons(rules, builds, nil, now)
+	expected := []bool{
+		false, true, false, false, true, true, //win32
+		false, false, false, //linux, the running build is never pruned
+		true,               //review
+		false, true, false, //osx
+	}
+	for i, pruned := range expected {
+		if (len(reasons[i]) > 0) != pruned {
+			t.Errorf("%s build %d (%s %s): got reason %q, pruned expected: %v",
+				builds[i].TargetSys, i, builds[i].Commit, builds[i].State, reasons[i], pruned)
+		}
+	}
+
+	//MaxAge prune by date, the latest master builds are still kept
+	rules = RetentionConfiguration{MaxAge: "90m"}
+	reasons = retentionReasons(rules, builds[:6], nil, now)
+	for i, pruned := range []bool{false, false, false, false, true, true} {
+		if (len(reasons[i]) > 0) != pruned {
+			t.Errorf("MaxAge build %d (%s): got reason %q, pruned expected: %v", i, builds[i].State, reasons[i], pruned)
+		}
+	}
+}
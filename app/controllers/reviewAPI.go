@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//reviewTimeout bound the requests to the review systems
+const reviewTimeout = 30 * time.Second
+
+//reviewAPI is the JSON client shared by the hosted review managers (GitHub, GitLab, Gitea)
+type reviewAPI struct {
+	baseURL    string
+	authHeader string
+	authValue  string
+	client     *http.Client
+}
+
+//do send a request with in as JSON body (if any) and decode the response in out (if any)
+func (a *reviewAPI) do(method string, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(a.baseURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(a.authValue) > 0 {
+		req.Header.Set(a.authHeader, a.authValue)
+	}
+
+	client := a.client
+	if client == nil {
+		client = &http.Client{Timeout: reviewTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s: %s %s", method, path, resp.Status, msg)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+//parsePullRef return the number of a pull/merge request ref (e.g refs/pull/42/head)
+func parsePullRef(ref string, prefix string) (string, bool) {
+	if !strings.HasPrefix(ref, prefix) || !strings.HasSuffix(ref, "/head") {
+		return "", false
+	}
+	number := strings.TrimSuffix(strings.TrimPrefix(ref, prefix), "/head")
+	if len(number) == 0 || strings.Contains(number, "/") {
+		return "", false
+	}
+	return number, true
+}
+
+//statusDescription is the description of a commit status sent for a build
+func statusDescription(build *Build) string {
+	return fmt.Sprintf("GoGo Build %s for %s: %s", build.ProjectToBuild.Name, build.TargetSys, build.State)
+}
+
+//statusContext identify the target sys in the commit statuses
+func statusContext(build *Build) string {
+	return "gogobuild/" + build.TargetSys
+}
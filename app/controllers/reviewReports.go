@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//reportQueue hold the builds to report to the review systems, the latest state of each build
+//Queueing never blocks: a build queued again before it is reported is only reported once,
+//with its latest state, so a slow review system delays the reports without holding the workers.
+type reportQueue struct {
+	mutex   sync.Mutex
+	pending map[bson.ObjectId]*Build
+	order   []bson.ObjectId
+	ready   chan struct{}
+}
+
+func newReportQueue() *reportQueue {
+	return &reportQueue{pending: make(map[bson.ObjectId]*Build), ready: make(chan struct{}, 1)}
+}
+
+//push queue a copy of build, replacing the state of the build not reported yet
+func (q *reportQueue) push(build *Build) {
+	reported := *build
+	q.mutex.Lock()
+	if _, found := q.pending[build.ID]; !found {
+		q.order = append(q.order, build.ID)
+	}
+	q.pending[build.ID] = &reported
+	q.mutex.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+//pop return the build queued first, nil if there is none
+func (q *reportQueue) pop() *Build {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if len(q.order) == 0 {
+		return nil
+	}
+	id := q.order[0]
+	q.order = q.order[1:]
+	build := q.pending[id]
+	delete(q.pending, id)
+	return build
+}
+
+//run report the queued builds one at a time, so a review never ends with the started state
+func (q *reportQueue) run(report func(build *Build)) {
+	for range q.ready {
+		for build := q.pop(); build != nil; build = q.pop() {
+			report(build)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestReportQueue(t *testing.T) {
+	q := newReportQueue()
+	first := &Build{ID: bson.NewObjectId(), State: Building}
+	second := &Build{ID: bson.NewObjectId(), State: Building}
+	q.push(first)
+	q.push(second)
+	first.State = Fail
+	q.push(first)
+	//The queue keeps a copy, later changes of the worker are not reported
+	first.State = Success
+
+	expected := []struct {
+		id    bson.ObjectId
+		state State
+	}{{first.ID, Fail}, {second.ID, Building}}
+	for _, e := range expected {
+		build := q.pop()
+		if build == nil || build.ID != e.id || build.State != e.state {
+			t.Fatalf("got %v, expected %s in state %s", build, e.id.Hex(), e.state)
+		}
+	}
+	if build := q.pop(); build != nil {
+		t.Errorf("got %v, expected an empty queue", build)
+	}
+
+	//Pushing never blocks, run get the latest state of each build
+	for i := 0; i < 1000; i++ {
+		q.push(&Build{ID: first.ID, State: Building})
+	}
+	q.push(first)
+	done := make(chan *Build, 10)
+	go q.run(func(build *Build) { done <- build })
+	if build := <-done; build.State != Success {
+		t.Errorf("got %s, expected the latest state", build.State)
+	}
+}
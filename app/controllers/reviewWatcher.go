@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"github.com/revel/revel"
+)
+
+//ReviewWatcher poll the open changes of a project and build the new patchsets
+//for all the target sys. It is scheduled with ReviewWatchSchedule of .packer.json
+//A change is built again when its head commit changes, e.g on a push to a pull request
+type ReviewWatcher struct {
+	projectName string
+	built       map[string]bool
+}
+
+//NewReviewWatcher return a watcher for the project
+func NewReviewWatcher(projectName string) *ReviewWatcher {
+	return &ReviewWatcher{projectName: projectName, built: make(map[string]bool)}
+}
+
+//Run check for new patchsets
+func (w *ReviewWatcher) Run() {
+	reviewManager := PMInstance().GetProjectByName(w.projectName).ReviewManagerInstance
+	if reviewManager == nil {
+		return
+	}
+	changes, err := reviewManager.GetOpenChanges()
+	if err != nil {
+		revel.WARN.Println(err)
+		return
+	}
+
+	//Changes already built are remembered across restarts through the build store
+	builds, err := BMInstance().GetBuildsByProjects(w.projectName)
+	if err != nil {
+		return
+	}
+	for _, build := range builds {
+		w.built[changeKey(build.Commit, build.GitCommitID)] = true
+	}
+
+	for _, change := range changes {
+		key := changeKey(change.Ref, change.SHA)
+		if w.built[key] {
+			continue
+		}
+		revel.INFO.Printf("New patchset %s (%s) for %s, building", change.Ref, change.SHA, w.projectName)
+		BMInstance().CreateReviewBuild(w.projectName, change)
+		w.built[key] = true
+	}
+}
+
+//changeKey identify a build of a change head
+func changeKey(ref string, sha string) string {
+	return ref + "@" + sha
+}
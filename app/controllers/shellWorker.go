@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/revel/revel"
+)
+
+//ShellWorker run the BuildInstructions on the host (BuildType "Shell")
+//Each build get its own scratch workspace, removed once the build is over.
+//The log and output directory are the same as a DockerWorker, /output being $GOGOBUILD_OUTPUT.
+type ShellWorker struct {
+	build     Build
+	outputDir string
+	workspace string
+	logFile   *os.File
+
+	mutex     sync.Mutex
+	cmd       *exec.Cmd
+	stopped   bool
+	stopState State
+
+	//update save the build, BMInstance().UpdateBuild when nil
+	update func(build *Build) error
+}
+
+func init() {
+	RegisterWorker("Shell", func(build *Build) Worker {
+		return &ShellWorker{build: *build}
+	})
+}
+
+//shellWorkspaceRoot return the directory of the build workspaces, build.workspace in app.conf
+func shellWorkspaceRoot() string {
+	return revel.Config.StringDefault("build.workspace", filepath.Join(os.TempDir(), "gogobuild"))
+}
+
+//Run the ShellWorker
+func (s *ShellWorker) Run() {
+	var err error
+
+	if s.isStopped() {
+		s.build.State = s.stoppedState()
+		s.save()
+		return
+	}
+	if timeout := s.build.ProjectToBuild.Configuration.GetBuildTimeout(s.build.TargetSys); timeout > 0 {
+		timer := time.AfterFunc(timeout, func() { s.stop(TimedOut) })
+		defer timer.Stop()
+	}
+
+	s.build.StartDate = time.Now()
+	s.build.State = Init
+	s.save()
+
+	//Create log file
+	s.outputDir = revel.BasePath + s.build.OutputPath()
+	os.MkdirAll(s.outputDir, 0777)
+	s.logFile, err = os.Create(s.outputDir + "/logs.txt")
+	if err != nil {
+		log.Println(err)
+		s.build.State = Fail
+		s.save()
+		return
+	}
+	defer s.logFile.Close()
+
+	if s.build.IsBuilderUpdate() {
+		s.setFinalState(errors.New("Shell builds have no builder image"))
+		return
+	}
+
+	s.workspace = filepath.Join(shellWorkspaceRoot(), s.build.ID.Hex())
+	if err := os.MkdirAll(s.workspace, 0777); err != nil {
+		s.setFinalState(err)
+		return
+	}
+	defer os.RemoveAll(s.workspace)
+
+	s.setFinalState(s.buildProject())
+}
+
+//buildProject run the instructions in the workspace, each of them recorded as a Step
+func (s *ShellWorker) buildProject() error {
+	instructions := s.build.BuildInstructions()
+	s.logFile.WriteString(strings.Join(instructions, "\n"))
+	s.logFile.WriteString("\n\n ---OUTPUT---- \n")
+
+	steps := &stepWriter{
+		build:    &s.build,
+		log:      s.logFile,
+		image:    "host",
+		commands: instructions,
+		update:   s.update,
+	}
+	cmd := exec.Command("bash", "-c", stepScript(instructions))
+	cmd.Dir = s.workspace
+	cmd.Env = append(os.Environ(), s.build.Env()...)
+	cmd.Env = append(cmd.Env,
+		"GOGOBUILD_WORKSPACE="+s.workspace,
+		"GOGOBUILD_OUTPUT="+s.outputDir,
+		"GOGOBUILD_PROJECT_DIR="+revel.BasePath+"/public/projects/"+s.build.ProjectToBuild.Name,
+	)
+	cmd.Stdout = steps
+	cmd.Stderr = steps
+	//Own process group so everything started by the build can be killed
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	s.started()
+
+	if err := s.start(cmd); err != nil {
+		s.logFile.WriteString("\n" + err.Error())
+		return err
+	}
+	err := cmd.Wait()
+
+	//The step running when the build stopped did not get to its end marker
+	stepState := Fail
+	if s.isStopped() {
+		stepState = s.stoppedState()
+	}
+	if err := steps.close(stepState); err != nil {
+		log.Println(err)
+	}
+
+	if s.isStopped() {
+		s.logFile.WriteString("\nBUILD " + strings.ToUpper(s.stoppedState().String()) + "\n")
+		return fmt.Errorf("Build %s", s.stoppedState())
+	}
+	if err != nil {
+		s.logFile.WriteString("\nBUILD FAILED\n")
+		return errors.New("Build failed")
+	}
+	s.logFile.WriteString("\nBUILD SUCCESS\n")
+	return nil
+}
+
+//setFinalState of the build, a build missing a required artifact is failed
+func (s *ShellWorker) setFinalState(err error) {
+	if err == nil && s.isStopped() == false {
+		if err = s.build.collectArtifacts(s.outputDir); err != nil {
+			s.logFile.WriteString("\nARTIFACTS: " + err.Error() + "\n")
+		}
+	} else if err != nil && s.build.IsBuilderUpdate() {
+		s.logFile.WriteString(err.Error() + "\n")
+	}
+	if s.isStopped() {
+		s.build.State = s.stoppedState()
+	} else if err != nil {
+		s.build.State = Fail
+	} else {
+		s.build.State = Success
+	}
+	s.save()
+}
+
+//save the build
+func (s *ShellWorker) save() error {
+	if s.update == nil {
+		return BMInstance().UpdateBuild(&s.build)
+	}
+	return s.update(&s.build)
+}
+
+//started set the build Building once its process is about to start
+func (s *ShellWorker) started() error {
+	if s.update == nil {
+		return BMInstance().StartBuild(&s.build)
+	}
+	s.build.State = Building
+	return s.update(&s.build)
+}
+
+//start the build process unless the build was already stopped
+func (s *ShellWorker) start(cmd *exec.Cmd) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stopped {
+		return fmt.Errorf("Build %s", s.stopState)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	s.cmd = cmd
+	return nil
+}
+
+//Reattach is not possible, the build processes died with the server
+func (s *ShellWorker) Reattach() error {
+	return errors.New("Shell builds can't be reattached")
+}
+
+//Cancel the build, its processes are killed
+func (s *ShellWorker) Cancel() {
+	s.stop(Cancelled)
+}
+
+//stop the build with the given final state (Cancelled, TimedOut)
+func (s *ShellWorker) stop(state State) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stopped {
+		return
+	}
+	s.stopped = true
+	s.stopState = state
+	log.Printf("Stopping build %s: %s", s.build.ID.Hex(), state)
+	if s.cmd != nil && s.cmd.Process != nil {
+		if err := syscall.Kill(-s.cmd.Process.Pid, syscall.SIGKILL); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (s *ShellWorker) isStopped() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.stopped
+}
+
+func (s *ShellWorker) stoppedState() State {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.stopState
+}
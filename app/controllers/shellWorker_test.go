@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+//newTestShellWorker run instructions in temporary workspace and output directories
+//The states the build is saved with are recorded
+func newTestShellWorker(t *testing.T, instructions ...string) (*ShellWorker, func() []State) {
+	dir, err := ioutil.TempDir("", "gogobuild-shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ShellWorker{
+		outputDir: filepath.Join(dir, "output"),
+		workspace: filepath.Join(dir, "workspace"),
+	}
+	os.Mkdir(s.outputDir, 0777)
+	os.Mkdir(s.workspace, 0777)
+	if s.logFile, err = os.Create(filepath.Join(s.outputDir, "logs.txt")); err != nil {
+		t.Fatal(err)
+	}
+	s.build = Build{ID: bson.NewObjectId(), TargetSys: "linux", Commit: "master", GitCommitID: "abc123"}
+	s.build.ProjectToBuild.Name = "ring"
+	s.build.ProjectToBuild.Configuration.BuildInstructions = map[string][]string{"linux": instructions}
+	s.build.ProjectToBuild.Configuration.Env = map[string]map[string]string{"linux": {"QT_VERSION": "5.15"}}
+
+	var mutex sync.Mutex
+	var states []State
+	s.update = func(build *Build) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		states = append(states, build.State)
+		return nil
+	}
+	return s, func() []State {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]State(nil), states...)
+	}
+}
+
+func closeTestShellWorker(s *ShellWorker) {
+	s.logFile.Close()
+	os.RemoveAll(filepath.Dir(s.outputDir))
+}
+
+//processAlive return true if the process runs, zombies waiting to be reaped are dead
+func processAlive(pid int) bool {
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	//pid (comm) state ...
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestShellWorkerEnv(t *testing.T) {
+	s, states := newTestShellWorker(t,
+		"env | grep -E '^(GOGOBUILD_|QT_VERSION)' | sort > $GOGOBUILD_OUTPUT/env.txt",
+		"pwd > $GOGOBUILD_OUTPUT/pwd.txt")
+	defer closeTestShellWorker(s)
+
+	if err := s.buildProject(); err != nil {
+		t.Fatal(err)
+	}
+	env, err := ioutil.ReadFile(filepath.Join(s.outputDir, "env.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"GOGOBUILD_BUILD_ID=" + s.build.ID.Hex(),
+		"GOGOBUILD_COMMIT_ID=abc123",
+		"GOGOBUILD_DEPLOY=false",
+		"GOGOBUILD_OUTPUT=" + s.outputDir,
+		"GOGOBUILD_PROJECT_DIR=/public/projects/ring",
+		"GOGOBUILD_REF=master",
+		"GOGOBUILD_RELEASE=" + s.build.ReleaseString(),
+		"GOGOBUILD_TARGET_SYS=linux",
+		"GOGOBUILD_WORKSPACE=" + s.workspace,
+		"QT_VERSION=5.15",
+	}
+	if got := strings.TrimSpace(string(env)); got != strings.Join(expected, "\n") {
+		t.Errorf("got environment\n%s\nexpected\n%s", got, strings.Join(expected, "\n"))
+	}
+	if pwd, _ := ioutil.ReadFile(filepath.Join(s.outputDir, "pwd.txt")); strings.TrimSpace(string(pwd)) != s.workspace {
+		t.Errorf("ran in %q, expected the workspace %s", pwd, s.workspace)
+	}
+
+	if got := states(); len(got) == 0 || got[0] != Building {
+		t.Errorf("got states %v, expected Building first", got)
+	}
+	if len(s.build.Steps) != 2 || s.build.Steps[0].State != Success || s.build.Steps[1].State != Success {
+		t.Errorf("got steps %+v", s.build.Steps)
+	}
+}
+
+func TestShellWorkerCancel(t *testing.T) {
+	for _, state := range []State{Cancelled, TimedOut} {
+		t.Run(state.String(), func(t *testing.T) {
+			//The background sleep is in the build process group, it must be killed too
+			s, _ := newTestShellWorker(t,
+				"sleep 60 & echo $! > $GOGOBUILD_OUTPUT/child.pid; sleep 60",
+				"touch $GOGOBUILD_OUTPUT/next-step")
+			defer closeTestShellWorker(s)
+
+			done := make(chan error, 1)
+			go func() { done <- s.buildProject() }()
+
+			pidFile := filepath.Join(s.outputDir, "child.pid")
+			var pid int
+			for deadline := time.Now().Add(10 * time.Second); pid == 0; time.Sleep(10 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatal("the build did not start")
+				}
+				content, _ := ioutil.ReadFile(pidFile)
+				pid, _ = strconv.Atoi(strings.TrimSpace(string(content)))
+			}
+			s.stop(state)
+
+			select {
+			case err := <-done:
+				if err == nil || !strings.Contains(err.Error(), state.String()) {
+					t.Errorf("got %v, expected the build %s", err, state)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("the build was not stopped")
+			}
+			for deadline := time.Now().Add(5 * time.Second); processAlive(pid); time.Sleep(10 * time.Millisecond) {
+				if time.Now().After(deadline) {
+					t.Fatalf("process %d of the build still runs", pid)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(s.outputDir, "next-step")); err == nil {
+				t.Error("the next step ran")
+			}
+			if len(s.build.Steps) != 1 || s.build.Steps[0].State != state {
+				t.Errorf("got steps %+v, expected the first one %s", s.build.Steps, state)
+			}
+			log, _ := ioutil.ReadFile(s.logFile.Name())
+			if !strings.Contains(string(log), "BUILD "+strings.ToUpper(state.String())) {
+				t.Errorf("got log %q", log)
+			}
+		})
+	}
+}
+
+func TestShellWorkerStoppedBeforeStart(t *testing.T) {
+	s, _ := newTestShellWorker(t, "touch $GOGOBUILD_OUTPUT/ran")
+	defer closeTestShellWorker(s)
+
+	s.Cancel()
+	if err := s.buildProject(); err == nil {
+		t.Error("a cancelled build ran")
+	}
+	if _, err := os.Stat(filepath.Join(s.outputDir, "ran")); err == nil {
+		t.Error("the instructions ran")
+	}
+}
@@ -2,17 +2,47 @@ package controllers
 
 import (
 	"errors"
+	"fmt"
+	"sync"
 
-	"github.com/revel/modules/jobs/app/jobs"
+	"github.com/revel/revel"
+	"gopkg.in/mgo.v2/bson"
 )
 
 //Worker interface
+//A Worker run one build and update its state until a final one
+//Reattach is called instead of a new Run after a restart, to follow a build left running
 type Worker interface {
 	Run()
+	Cancel()
+	Reattach() error
+}
+
+//Placer is a Worker running on a pool of hosts
+//Place reserve a slot for the build, ErrNoFreeHost keep it queued
+type Placer interface {
+	Place() error
+}
+
+//WorkerFactory create the Worker of a build
+type WorkerFactory func(build *Build) Worker
+
+//workerFactories by BuildType of .packer.json
+var workerFactories = make(map[string]WorkerFactory)
+
+//RegisterWorker make a BuildType available, executors register themselves in their init
+func RegisterWorker(buildType string, factory WorkerFactory) {
+	workerFactories[buildType] = factory
 }
 
 //WorkerManager singleton
+//Queued builds are kept by the BuildManager, the WorkerManager start them
+//by priority as soon as one of their slots is free. Placers have the slots of their
+//hosts (the docker hosts capacities), the other workers share build.slots of app.conf.
 type WorkerManager struct {
+	mutex   sync.Mutex
+	slots   int
+	workers map[bson.ObjectId]Worker
 }
 
 //instance of WorkerManager
@@ -22,31 +52,158 @@ var instance *WorkerManager
 func WMInstance() *WorkerManager {
 	if instance == nil {
 		instance = new(WorkerManager)
+		instance.slots = revel.Config.IntDefault("build.slots", 4)
+		instance.workers = make(map[bson.ObjectId]Worker)
 	}
 	return instance
 }
 
-//Build Launch a build
-//Build queue is restricted by jobs.pool = 4 in app.conf (FIFO)
-func (w *WorkerManager) Build(build *Build) error {
+//Dispatch start the next queued builds while there are free slots
+//The worker takes its slot before being placed, placement (which may query the docker hosts)
+//is done without holding the mutex
+func (w *WorkerManager) Dispatch() {
+	var failed []Build
+	var waiting []Build
+
+	for {
+		queue, err := BMInstance().GetQueue()
+		if err != nil {
+			revel.ERROR.Println(err)
+			break
+		}
+
+		w.mutex.Lock()
+		next := -1
+		for i := range queue {
+			if _, running := w.workers[queue[i].ID]; !running && !containsBuild(failed, queue[i].ID) && !containsBuild(waiting, queue[i].ID) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			w.mutex.Unlock()
+			break
+		}
+		build := queue[next]
+		worker, err := w.newWorker(&build)
+		placer, placed := worker.(Placer)
+		if err == nil && !placed && w.running(false) >= w.slots {
+			//Builds placed on hosts may still start
+			waiting = append(waiting, build)
+			w.mutex.Unlock()
+			continue
+		}
+		if err == nil {
+			//Hold the slot, a Cancel during the placement stops the worker before it runs
+			w.workers[build.ID] = worker
+		}
+		w.mutex.Unlock()
+
+		if placed && err == nil {
+			err = placer.Place()
+		}
+
+		w.mutex.Lock()
+		if err != nil && w.workers[build.ID] == worker {
+			delete(w.workers, build.ID)
+		}
+		if err == ErrNoFreeHost {
+			//Retried once a build ends, the next builds may run on other hosts
+			waiting = append(waiting, build)
+		} else if err != nil {
+			revel.WARN.Printf("Build %s can't start: %s", build.ID.Hex(), err)
+			build.State = Fail
+			failed = append(failed, build)
+		} else {
+			w.start(build.ID, worker)
+		}
+		w.mutex.Unlock()
+	}
+
+	for i := range failed {
+		BMInstance().UpdateBuild(&failed[i])
+	}
+}
+
+//running return the number of workers placed on hosts (placed) or using build.slots
+//Must be called with the mutex held
+func (w *WorkerManager) running(placed bool) int {
+	count := 0
+	for _, worker := range w.workers {
+		if _, ok := worker.(Placer); ok == placed {
+			count++
+		}
+	}
+	return count
+}
+
+//Reattach a build to the work it left running before a restart
+func (w *WorkerManager) Reattach(build *Build) error {
+	worker, err := w.newWorker(build)
+	if err != nil {
+		return err
+	}
+	if err := worker.Reattach(); err != nil {
+		return err
+	}
+	w.mutex.Lock()
+	w.start(build.ID, worker)
+	w.mutex.Unlock()
+	return nil
+}
+
+func (w *WorkerManager) newWorker(build *Build) (Worker, error) {
+	factory, ok := workerFactories[build.ProjectToBuild.Configuration.BuildType]
+	if !ok {
+		return nil, fmt.Errorf("Not a valid build type %s", build.ProjectToBuild.Configuration.BuildType)
+	}
+	return factory(build), nil
+}
 
-	var launchFunc func(build *Build, targetSys string) Worker
+//start the worker, it can be cancelled until it returns
+//Must be called with the mutex held
+func (w *WorkerManager) start(id bson.ObjectId, worker Worker) {
+	w.workers[id] = worker
 
-	switch build.ProjectToBuild.Configuration.BuildType {
-	case "Docker":
-		launchFunc = w.launchDockerBuild
-	default:
-		build.State = Fail
-		return errors.New("Not a valid build type")
+	go func() {
+		worker.Run()
+		w.mutex.Lock()
+		if w.workers[id] == worker {
+			delete(w.workers, id)
+		}
+		w.mutex.Unlock()
+		w.Dispatch()
+	}()
+}
+
+//Cancel a running build or drop it from the queue
+func (w *WorkerManager) Cancel(build *Build) error {
+	w.mutex.Lock()
+	worker, ok := w.workers[build.ID]
+	if build.State == Created {
+		//Still queued or being placed, mark it before Dispatch can start it
+		//Like the other final states it is notified and reported to the review system
+		build.State = Cancelled
+		err := BMInstance().UpdateBuild(build)
+		w.mutex.Unlock()
+		if ok {
+			worker.Cancel()
+		}
+		return err
+	}
+	w.mutex.Unlock()
+	if !ok {
+		return errors.New("No worker found for this build")
 	}
-	jobs.Now(launchFunc(build, build.TargetSys))
+	worker.Cancel()
 	return nil
 }
 
-func (w *WorkerManager) launchDockerBuild(build *Build, targetSys string) Worker {
-	d := DockerWorker{
-		build:     *build,
-		targetSys: targetSys,
+func containsBuild(builds []Build, id bson.ObjectId) bool {
+	for _, build := range builds {
+		if build.ID == id {
+			return true
+		}
 	}
-	return d
+	return false
 }